@@ -0,0 +1,428 @@
+package etf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"unicode/utf8"
+)
+
+// ErrNotFound is returned, wrapped, by TupleGet and MapGet when the
+// requested element or key doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// ConversionError is returned by As and the functions built on it when
+// a term can't be converted to the requested type.
+type ConversionError struct {
+	Term   Term
+	Type   reflect.Type
+	Reason string
+
+	// Err is the error converting an element of Term, if that is why
+	// the conversion failed.
+	Err error
+}
+
+func (err *ConversionError) Error() string {
+	msg := fmt.Sprintf("can't convert %s to %v", Format(err.Term), err.Type)
+	if err.Reason != "" {
+		msg += ": " + err.Reason
+	}
+	if err.Err != nil {
+		msg += ": " + err.Err.Error()
+	}
+	return msg
+}
+
+func (err *ConversionError) Unwrap() error {
+	return err.Err
+}
+
+// As converts t to a T. It is a checked type assertion that also
+// performs the conversions that are safe between the ways that the
+// same Erlang value can be represented in Go:
+//
+//   - Any integer converts to any Go integer type that can hold its
+//     value, to a float type that can hold it exactly, or to *big.Int.
+//     Floats only convert to float types.
+//   - Strings, which are charlists, Charlist, []byte, Binary and lists
+//     of integers convert to string, Charlist, []byte and Binary. A
+//     list converts to a string by treating its elements as Unicode
+//     code points and a Go string by treating its bytes as Latin-1
+//     characters, so both result in UTF-8 text.
+//   - Strings also convert to List, as lists of their bytes, and
+//     Charlists as lists of their code points.
+//   - The atoms true and false convert to bool and back.
+//   - A tuple converts to a struct with the same number of exported
+//     fields by converting each element to the type of the field, the
+//     reverse of how the Encoder encodes structs.
+//   - A proper list converts to a slice or an array of the same length
+//     by converting each element.
+//   - Anything that converts to a T also converts to a *T.
+//
+// If T is an interface type, such as Term, t is returned as is as long
+// as it implements it.
+func As[T any](t Term) (T, error) {
+	var out T
+	v, err := convert(t, reflect.TypeFor[T]())
+	if err != nil {
+		return out, err
+	}
+	reflect.ValueOf(&out).Elem().Set(v)
+	return out, nil
+}
+
+var (
+	atomType   = reflect.TypeFor[Atom]()
+	stringType = reflect.TypeFor[string]()
+	listType   = reflect.TypeFor[List]()
+	bigIntType = reflect.TypeFor[*big.Int]()
+
+	// termStructs are the struct types that represent terms other
+	// than tuples, so tuples don't convert to them.
+	termStructs = map[reflect.Type]bool{
+		reflect.TypeFor[Pid]():          true,
+		reflect.TypeFor[Port]():         true,
+		reflect.TypeFor[Ref]():          true,
+		reflect.TypeFor[Function]():     true,
+		reflect.TypeFor[Export]():       true,
+		reflect.TypeFor[ImproperList](): true,
+		reflect.TypeFor[big.Int]():      true,
+	}
+)
+
+// convert implements As for an arbitrary type.
+func convert(t Term, rt reflect.Type) (reflect.Value, error) {
+	fail := func(reason string, args ...any) (reflect.Value, error) {
+		return reflect.Value{}, &ConversionError{Term: t, Type: rt, Reason: fmt.Sprintf(reason, args...)}
+	}
+
+	if t == nil {
+		if rt.Kind() == reflect.Interface {
+			return reflect.Zero(rt), nil
+		}
+		return fail("")
+	}
+
+	// A Go string holds Latin-1 bytes, so it is transcoded to UTF-8
+	// below instead of being returned as is.
+	tt := reflect.TypeOf(t)
+	if tt == rt && tt != stringType || (rt.Kind() == reflect.Interface && tt.Implements(rt)) {
+		return reflect.ValueOf(t), nil
+	}
+
+	switch {
+	case rt == atomType:
+		if b, ok := t.(bool); ok {
+			return reflect.ValueOf(Atom(atomText(b))), nil
+		}
+
+	case rt == bigIntType:
+		i, _, isFloat, ok := numberValue(t)
+		if !ok {
+			break
+		}
+		if isFloat {
+			return fail("value is a float")
+		}
+		return reflect.ValueOf(new(big.Int).Set(i)), nil
+
+	case rt == listType:
+		switch t.(type) {
+		case string, Charlist:
+			elems, _ := listParts(t)
+			return reflect.ValueOf(List(elems)), nil
+		}
+	}
+
+	v := reflect.New(rt).Elem()
+	switch rt.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, _, isFloat, ok := numberValue(t)
+		if !ok {
+			break
+		}
+		if isFloat {
+			return fail("value is a float")
+		}
+		if !i.IsInt64() || v.OverflowInt(i.Int64()) {
+			return fail("value out of range")
+		}
+		v.SetInt(i.Int64())
+		return v, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, _, isFloat, ok := numberValue(t)
+		if !ok {
+			break
+		}
+		if isFloat {
+			return fail("value is a float")
+		}
+		if !i.IsUint64() || v.OverflowUint(i.Uint64()) {
+			return fail("value out of range")
+		}
+		v.SetUint(i.Uint64())
+		return v, nil
+
+	case reflect.Float32, reflect.Float64:
+		i, f, isFloat, ok := numberValue(t)
+		if !ok {
+			break
+		}
+		if !isFloat {
+			var acc big.Accuracy
+			f, acc = new(big.Float).SetInt(i).Float64()
+			if acc != big.Exact || rt.Kind() == reflect.Float32 && float64(float32(f)) != f {
+				return fail("value out of range")
+			}
+		}
+		if math.IsInf(f, 0) || v.OverflowFloat(f) {
+			return fail("value out of range")
+		}
+		v.SetFloat(f)
+		return v, nil
+
+	case reflect.Bool:
+		switch t {
+		case Atom("true"):
+			v.SetBool(true)
+			return v, nil
+		case Atom("false"):
+			return v, nil
+		}
+
+	case reflect.String:
+		if rt == atomType {
+			break
+		}
+		s, ok, err := textValue(t)
+		if err != nil {
+			return fail("%v", err)
+		}
+		if ok {
+			v.SetString(s)
+			return v, nil
+		}
+
+	case reflect.Slice:
+		if rt.Elem().Kind() == reflect.Uint8 {
+			s, ok, err := textValue(t)
+			if err != nil {
+				return fail("%v", err)
+			}
+			if ok {
+				v.SetBytes([]byte(s))
+				return v, nil
+			}
+			break
+		}
+		fallthrough
+
+	case reflect.Array:
+		elems, tail, ok := listOf(t)
+		if !ok || termClass(tail) != classNil {
+			break
+		}
+		if rt.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(rt, len(elems), len(elems)))
+		} else if len(elems) != rt.Len() {
+			return fail("expected %d elements, got %d", rt.Len(), len(elems))
+		}
+		for i, e := range elems {
+			ev, err := convert(e, rt.Elem())
+			if err != nil {
+				return reflect.Value{}, &ConversionError{Term: t, Type: rt, Reason: fmt.Sprintf("element %d", i), Err: err}
+			}
+			v.Index(i).Set(ev)
+		}
+		return v, nil
+
+	case reflect.Struct:
+		tuple, ok := t.(Tuple)
+		if !ok || termStructs[rt] {
+			break
+		}
+		fields := recordFields(rt)
+		if len(tuple) != len(fields) {
+			return fail("expected %d-tuple, got %d-tuple", len(fields), len(tuple))
+		}
+		for i, f := range fields {
+			ev, err := convert(tuple[i], rt.Field(f).Type)
+			if err != nil {
+				return reflect.Value{}, &ConversionError{Term: t, Type: rt, Reason: "field " + rt.Field(f).Name, Err: err}
+			}
+			v.Field(f).Set(ev)
+		}
+		return v, nil
+
+	case reflect.Pointer:
+		ev, err := convert(t, rt.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v.Set(reflect.New(rt.Elem()))
+		v.Elem().Set(ev)
+		return v, nil
+	}
+
+	return fail("")
+}
+
+// recordFields returns the indexes of the fields of a struct type that
+// are encoded as tuple elements.
+func recordFields(rt reflect.Type) []int {
+	fields := make([]int, 0, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.Anonymous || !field.IsExported() {
+			continue
+		}
+		fields = append(fields, i)
+	}
+	return fields
+}
+
+// numberValue returns the value of t if it is a number.
+func numberValue(t Term) (i *big.Int, f float64, isFloat, ok bool) {
+	switch t.(type) {
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uintptr, uint, *big.Int, float32, float64:
+		i, f, isFloat = number(t)
+		return i, f, isFloat, true
+	}
+	return nil, 0, false, false
+}
+
+// textValue returns the contents of a string, binary or list of
+// character codes as UTF-8 text. Go strings hold the bytes of a
+// STRING_EXT, which are Latin-1 characters.
+func textValue(t Term) (s string, ok bool, err error) {
+	switch t := t.(type) {
+	case string:
+		return string(latin1ToUTF8([]byte(t))), true, nil
+	case Charlist:
+		return string(t), true, nil
+	case []byte:
+		return string(t), true, nil
+	case Binary:
+		return string(t), true, nil
+	case List:
+		buf := make([]byte, 0, len(t))
+		for i, e := range t {
+			c, err := As[rune](e)
+			if err != nil || !utf8.ValidRune(c) {
+				return "", false, fmt.Errorf("element %d is not a character", i)
+			}
+			buf = utf8.AppendRune(buf, c)
+		}
+		return string(buf), true, nil
+	}
+	return "", false, nil
+}
+
+// Get returns the ith element of t, counting from 1 as Erlang's
+// element/2 does. It returns false if i is out of range.
+func (t Tuple) Get(i int) (Term, bool) {
+	if i < 1 || i > len(t) {
+		return nil, false
+	}
+	return t[i-1], true
+}
+
+// Get returns the value associated with key in m. Keys are matched
+// using ExactEqual, as in Erlang, so an integer key doesn't find a float
+// key with the same value.
+func (m Map) Get(key Term) (Term, bool) {
+	for _, e := range m {
+		if ExactEqual(e.Key, key) {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// TupleGet returns the ith element of t, counting from 1, converted to
+// a T by As.
+func TupleGet[T any](t Tuple, i int) (T, error) {
+	e, ok := t.Get(i)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("element %d of %d-tuple: %w", i, len(t), ErrNotFound)
+	}
+	v, err := As[T](e)
+	if err != nil {
+		return v, fmt.Errorf("element %d of %d-tuple: %w", i, len(t), err)
+	}
+	return v, nil
+}
+
+// MapGet returns the value associated with key in m converted to a T
+// by As.
+func MapGet[T any](m Map, key Term) (T, error) {
+	e, ok := m.Get(key)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("key %s: %w", Format(key), ErrNotFound)
+	}
+	v, err := As[T](e)
+	if err != nil {
+		return v, fmt.Errorf("key %s: %w", Format(key), err)
+	}
+	return v, nil
+}
+
+// MatchTuple checks that t is a tuple with the same number of elements
+// as pattern and matches each of its elements against the
+// corresponding element of pattern. A non-nil pointer in pattern
+// receives the element, converted by As to the type that it points to.
+// A nil element matches anything. Any other value is a literal that
+// must be ExactEqual to the element. For example,
+//
+//	var n int
+//	err := etf.MatchTuple(reply, etf.Atom("ok"), &n)
+//
+// checks that reply is {ok, N} with an integer N and stores N in n.
+// Because *big.Int is itself a term, it is treated as a literal; pass
+// a **big.Int to receive a big integer.
+//
+// Elements are stored as they are matched, so some pointers may have
+// been written to when an error is returned.
+func MatchTuple(t Term, pattern ...any) error {
+	tuple, ok := t.(Tuple)
+	if !ok {
+		return fmt.Errorf("expected %d-tuple, got %s", len(pattern), Format(t))
+	}
+	if len(tuple) != len(pattern) {
+		return fmt.Errorf("expected %d-tuple, got %d-tuple %s", len(pattern), len(tuple), Format(t))
+	}
+
+	for i, p := range pattern {
+		e := tuple[i]
+		switch p := p.(type) {
+		case nil:
+			continue
+		case *big.Int:
+			if !ExactEqual(p, e) {
+				return fmt.Errorf("element %d of %s: expected %s", i+1, Format(t), Format(p))
+			}
+			continue
+		}
+
+		rv := reflect.ValueOf(p)
+		if rv.Kind() == reflect.Pointer && !rv.IsNil() {
+			v, err := convert(e, rv.Type().Elem())
+			if err != nil {
+				return fmt.Errorf("element %d of %s: %w", i+1, Format(t), err)
+			}
+			rv.Elem().Set(v)
+			continue
+		}
+
+		if _, ok := normalize(p); !ok || !ExactEqual(p, e) {
+			return fmt.Errorf("element %d of %s: expected %s", i+1, Format(t), Format(p))
+		}
+	}
+	return nil
+}
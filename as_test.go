@@ -0,0 +1,220 @@
+package etf
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestAs(t *testing.T) {
+	if v, err := As[int](int64(42)); err != nil || v != 42 {
+		t.Errorf("As[int](42): got %v, %v", v, err)
+	}
+	if v, err := As[uint8](255); err != nil || v != 255 {
+		t.Errorf("As[uint8](255): got %v, %v", v, err)
+	}
+	if _, err := As[uint8](256); err == nil {
+		t.Error("As[uint8](256): expected error")
+	}
+	if _, err := As[uint](-1); err == nil {
+		t.Error("As[uint](-1): expected error")
+	}
+	if _, err := As[int](1.0); err == nil {
+		t.Error("As[int](1.0): expected error")
+	}
+	if v, err := As[float64](3); err != nil || v != 3 {
+		t.Errorf("As[float64](3): got %v, %v", v, err)
+	}
+	if _, err := As[float32](math.MaxFloat64); err == nil {
+		t.Error("As[float32](MaxFloat64): expected error")
+	}
+	if _, err := As[float64](int64(1)<<53 + 1); err == nil {
+		t.Error("As[float64](2^53+1): expected error")
+	}
+	if _, err := As[float32](1<<24 + 1); err == nil {
+		t.Error("As[float32](2^24+1): expected error")
+	}
+	if v, err := As[float64](int64(1) << 60); err != nil || v != 1<<60 {
+		t.Errorf("As[float64](2^60): got %v, %v", v, err)
+	}
+
+	big1 := new(big.Int).Lsh(big.NewInt(1), 70)
+	if _, err := As[int64](big1); err == nil {
+		t.Error("As[int64](2^70): expected error")
+	}
+	if v, err := As[*big.Int](uint64(math.MaxUint64)); err != nil || v.Uint64() != math.MaxUint64 {
+		t.Errorf("As[*big.Int](MaxUint64): got %v, %v", v, err)
+	}
+	if v, err := As[int](big.NewInt(7)); err != nil || v != 7 {
+		t.Errorf("As[int](big 7): got %v, %v", v, err)
+	}
+
+	if v, err := As[string]([]byte("héllo")); err != nil || v != "héllo" {
+		t.Errorf("As[string](binary): got %q, %v", v, err)
+	}
+	if v, err := As[string](List{104, 233}); err != nil || v != "hé" {
+		t.Errorf("As[string](charlist): got %q, %v", v, err)
+	}
+	if v, err := As[string]("h\xe9llo"); err != nil || v != "héllo" {
+		t.Errorf("As[string](STRING_EXT): got %q, %v", v, err)
+	}
+	if _, err := As[string](List{Atom("a")}); err == nil {
+		t.Error("As[string]([a]): expected error")
+	}
+	if v, err := As[Binary]("abc"); err != nil || string(v) != "abc" {
+		t.Errorf("As[Binary](\"abc\"): got %q, %v", v, err)
+	}
+	if v, err := As[List]("ab"); err != nil || !Equal(v, List{97, 98}) {
+		t.Errorf("As[List](\"ab\"): got %v, %v", v, err)
+	}
+	if _, err := As[string](Atom("abc")); err == nil {
+		t.Error("As[string](abc): expected error")
+	}
+
+	if v, err := As[bool](Atom("true")); err != nil || !v {
+		t.Errorf("As[bool](true): got %v, %v", v, err)
+	}
+	if v, err := As[Atom](false); err != nil || v != "false" {
+		t.Errorf("As[Atom](false): got %v, %v", v, err)
+	}
+
+	if v, err := As[Term](Tuple{1}); err != nil || !Equal(v, Tuple{1}) {
+		t.Errorf("As[Term]({1}): got %v, %v", v, err)
+	}
+	if v, err := As[Term](nil); err != nil || v != nil {
+		t.Errorf("As[Term](nil): got %v, %v", v, err)
+	}
+
+	_, err := As[Tuple](Atom("ok"))
+	var cerr *ConversionError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expected ConversionError, got %v", err)
+	}
+	if msg := err.Error(); msg != "can't convert ok to etf.Tuple" {
+		t.Errorf("unexpected error message %q", msg)
+	}
+}
+
+func TestTupleGet(t *testing.T) {
+	tuple := Tuple{Atom("ok"), 1, "abc"}
+
+	if v, err := TupleGet[Atom](tuple, 1); err != nil || v != "ok" {
+		t.Errorf("element 1: got %v, %v", v, err)
+	}
+	if v, err := TupleGet[int64](tuple, 2); err != nil || v != 1 {
+		t.Errorf("element 2: got %v, %v", v, err)
+	}
+	if _, err := TupleGet[int](tuple, 4); !errors.Is(err, ErrNotFound) {
+		t.Errorf("element 4: expected ErrNotFound, got %v", err)
+	}
+	_, err := TupleGet[int](tuple, 3)
+	if msg := "element 3 of 3-tuple: can't convert \"abc\" to int"; err == nil || err.Error() != msg {
+		t.Errorf("element 3: expected %q, got %v", msg, err)
+	}
+
+	if _, ok := tuple.Get(0); ok {
+		t.Error("Get(0) succeeded")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Element(4): expected panic")
+			}
+		}()
+		tuple.Element(4)
+	}()
+}
+
+func TestMapGet(t *testing.T) {
+	m := Map{
+		{Atom("name"), []byte("node")},
+		{1, Atom("int")},
+		{1.0, Atom("float")},
+	}
+
+	if v, err := MapGet[string](m, Atom("name")); err != nil || v != "node" {
+		t.Errorf("name: got %q, %v", v, err)
+	}
+	if v, err := MapGet[Atom](m, 1.0); err != nil || v != "float" {
+		t.Errorf("1.0: got %v, %v", v, err)
+	}
+	if v, err := MapGet[Atom](m, uint8(1)); err != nil || v != "int" {
+		t.Errorf("1: got %v, %v", v, err)
+	}
+	if _, err := MapGet[int](m, Atom("missing")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMatchTuple(t *testing.T) {
+	var n int
+	var rest Term
+	if err := MatchTuple(Tuple{Atom("ok"), 5, List{}}, Atom("ok"), &n, &rest); err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 || !Equal(rest, List{}) {
+		t.Errorf("got %v, %v", n, rest)
+	}
+
+	tests := []struct {
+		in      Term
+		pattern []any
+	}{
+		{Tuple{Atom("error"), 5}, []any{Atom("ok"), &n}},
+		{Tuple{Atom("ok"), 5}, []any{Atom("ok")}},
+		{Tuple{Atom("ok"), 5.0}, []any{Atom("ok"), &n}},
+		{Tuple{Atom("ok"), 5.0}, []any{nil, 5}},
+		{List{Atom("ok"), 5}, []any{nil, nil}},
+	}
+	for _, test := range tests {
+		if err := MatchTuple(test.in, test.pattern...); err == nil {
+			t.Errorf("%v: expected error", test.in)
+		}
+	}
+
+	big1 := new(big.Int).Lsh(big.NewInt(1), 70)
+	var b *big.Int
+	if err := MatchTuple(Tuple{big1, big1}, big1, &b); err != nil || b.Cmp(big1) != 0 {
+		t.Errorf("big: got %v, %v", b, err)
+	}
+}
+
+func TestAsStruct(t *testing.T) {
+	type point struct {
+		X, Y int
+		tag  string
+	}
+	type shape struct {
+		Name   string
+		Points []point
+		Origin *point
+	}
+
+	in := Tuple{[]byte("tri"), List{Tuple{0, 0}, Tuple{1, 0}, Tuple{0, 1}}, Tuple{2, 3}}
+	v, err := As[shape](in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "tri" || len(v.Points) != 3 || v.Points[2].Y != 1 || v.Origin == nil || v.Origin.Y != 3 {
+		t.Errorf("unexpected result %+v", v)
+	}
+
+	if _, err := As[[2]int](List{1, 2}); err != nil {
+		t.Error(err)
+	}
+	if _, err := As[[2]int](List{1, 2, 3}); err == nil {
+		t.Error("expected error for wrong array length")
+	}
+	if _, err := As[Pid](Tuple{Atom("n"), 1, 2, 3}); err == nil {
+		t.Error("converted a tuple to a Pid")
+	}
+
+	_, err = As[shape](Tuple{"tri", List{Tuple{0, 1.5}}, Tuple{0, 0}})
+	exp := `can't convert {"tri", [{0, 1.5}], {0, 0}} to etf.shape: field Points: ` +
+		`can't convert [{0, 1.5}] to []etf.point: element 0: ` +
+		`can't convert {0, 1.5} to etf.point: field Y: can't convert 1.5 to int: value is a float`
+	if err == nil || err.Error() != exp {
+		t.Errorf("expected %q, got %v", exp, err)
+	}
+}
@@ -0,0 +1,145 @@
+// etfconv converts terms between the Erlang external term format, JSON
+// and Erlang term syntax.
+//
+// Usage:
+//
+//	etfconv -from FORMAT -to FORMAT [file]
+//
+// FORMAT is one of etf, json or erl. If no file is given, the input is
+// read from stdin, and the output is always written to stdout. The
+// input may contain multiple terms: ETF input is a sequence of terms
+// that each start with the version byte, JSON input is a sequence of
+// JSON values, and Erlang input is a sequence of terms that are each
+// terminated by a period, as read by file:consult/1.
+//
+// JSON conversion uses the reversible mapping described by
+// etf.ToJSON, or the lossy one if -friendly is given.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/DeedleFake/etf"
+)
+
+func main() {
+	from := flag.String("from", "etf", "input format (etf, json or erl)")
+	to := flag.String("to", "json", "output format (etf, json or erl)")
+	friendly := flag.Bool("friendly", false, "use the lossy, friendlier JSON mapping")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v -from FORMAT -to FORMAT [file]\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var r io.Reader = os.Stdin
+	if path := flag.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "etfconv: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	err := convert(w, r, *from, *to, etf.JSONOptions{Friendly: *friendly})
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "etfconv: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// convert reads terms in the from format from r and writes them to w
+// in the to format.
+func convert(w io.Writer, r io.Reader, from, to string, opts etf.JSONOptions) error {
+	write, err := writer(w, to, opts)
+	if err != nil {
+		return err
+	}
+
+	switch from {
+	case "etf":
+		for t, err := range new(etf.Context).Decoder(r).All() {
+			if err != nil {
+				return err
+			}
+			if err := write(t); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "json":
+		d := json.NewDecoder(r)
+		for {
+			var raw json.RawMessage
+			if err := d.Decode(&raw); errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			t, err := etf.FromJSON(raw, opts)
+			if err != nil {
+				return err
+			}
+			if err := write(t); err != nil {
+				return err
+			}
+		}
+
+	case "erl":
+		text, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		terms, err := etf.ParseTerms(string(text))
+		if err != nil {
+			return err
+		}
+		for _, t := range terms {
+			if err := write(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown input format %q", from)
+}
+
+func writer(w io.Writer, format string, opts etf.JSONOptions) (func(etf.Term) error, error) {
+	switch format {
+	case "etf":
+		e := new(etf.Context).Encoder(w)
+		return func(t etf.Term) error { return e.Encode(t) }, nil
+
+	case "json":
+		return func(t etf.Term) error {
+			b, err := etf.ToJSON(t, opts)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(append(b, '\n'))
+			return err
+		}, nil
+
+	case "erl":
+		return func(t etf.Term) error {
+			_, err := fmt.Fprintf(w, "%s.\n", etf.Format(t))
+			return err
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
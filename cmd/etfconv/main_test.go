@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/DeedleFake/etf"
+)
+
+func TestConvert(t *testing.T) {
+	test := func(in, from, to, exp string) {
+		var out bytes.Buffer
+		if err := convert(&out, strings.NewReader(in), from, to, etf.JSONOptions{}); err != nil {
+			t.Errorf("%s: %v", in, err)
+		} else if v := out.String(); v != exp {
+			t.Errorf("%s: expected %q, got %q", in, exp, v)
+		}
+	}
+
+	test("{ok, <<\"bin\">>}.", "erl", "json", `{"$tuple":[{"$atom":"ok"},"bin"]}`+"\n")
+	test(`{"$tuple":[{"$atom":"ok"},"bin"]}`, "json", "erl", "{ok, <<\"bin\">>}.\n")
+	test("\x83h\x02w\x02okm\x00\x00\x00\x03bin", "etf", "erl", "{ok, <<\"bin\">>}.\n")
+	test("{ok, 1}.", "erl", "etf", "\x83h\x02w\x02oka\x01")
+	test(`{"a": [1, 2.5, null, true]}`, "json", "erl", "#{<<\"a\">> => [1, 2.5, nil, true]}.\n")
+	test("1. 2.", "erl", "json", "1\n2\n")
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	test := func(in string) {
+		var j, erl bytes.Buffer
+		if err := convert(&j, strings.NewReader(in+"."), "erl", "json", etf.JSONOptions{}); err != nil {
+			t.Fatal(in, err)
+		}
+		if err := convert(&erl, bytes.NewReader(j.Bytes()), "json", "erl", etf.JSONOptions{}); err != nil {
+			t.Fatal(in, err)
+		}
+		if v := strings.TrimSuffix(erl.String(), ".\n"); v != in {
+			t.Errorf("expected %s, got %s (via %s)", in, v, j.String())
+		}
+	}
+
+	test("{ok, 1.0, -3}")
+	test("[1, 2 | t]")
+	test(`#{<<"k">> => "str", {a} => <<1,255>>}`)
+	test("123456789012345678901234567890")
+	test("[#{}, #{<<\"$tag\">> => 1}]")
+}
+
+func TestConvertError(t *testing.T) {
+	test := func(in, from, to string) {
+		var out bytes.Buffer
+		if err := convert(&out, strings.NewReader(in), from, to, etf.JSONOptions{}); err == nil {
+			t.Errorf("%s: err == nil", in)
+		}
+	}
+
+	test("1.", "erl", "yaml")
+	test("1.", "yaml", "erl")
+	test(`{"$unknown": 1}`, "json", "erl")
+	test(`{"$string": 1}`, "json", "erl")
+	test("{ok", "erl", "json")
+}
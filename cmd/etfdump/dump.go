@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/DeedleFake/etf"
+)
+
+// dumpRowSize is the number of bytes printed per line of payload.
+const dumpRowSize = 16
+
+// dump writes an annotated hex dump of the terms in data to w. Each
+// line shows the offset of the bytes on it, the bytes themselves and,
+// for headers, the name of the tag and the values of its fields.
+func dump(w io.Writer, data []byte) error {
+	d := dumper{w: w, data: data}
+	for d.off < len(data) {
+		if err := d.term(0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type dumper struct {
+	w    io.Writer
+	data []byte
+	off  int
+}
+
+func (d *dumper) need(n int) error {
+	if len(d.data)-d.off < n {
+		return fmt.Errorf("offset %d: need %d bytes, have %d", d.off, n, len(d.data)-d.off)
+	}
+	return nil
+}
+
+// line prints the next n bytes as a header with the given annotation.
+func (d *dumper) line(depth, n int, format string, args ...any) {
+	b := d.data[d.off : d.off+n]
+	hex := fmt.Sprintf("% x", b)
+	fmt.Fprintf(d.w, "%08x  %-*s  %s%s\n", d.off, dumpRowSize*3-1, hex, strings.Repeat("  ", depth), fmt.Sprintf(format, args...))
+	d.off += n
+}
+
+// payload prints the next n bytes as raw data.
+func (d *dumper) payload(n int) {
+	for n > 0 {
+		row := min(n, dumpRowSize)
+		fmt.Fprintf(d.w, "%08x  % x\n", d.off, d.data[d.off:d.off+row])
+		d.off += row
+		n -= row
+	}
+}
+
+func (d *dumper) uint(size int) int {
+	var v int
+	for _, c := range d.data[d.off+1 : d.off+1+size] {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+// term dumps the next term and any terms nested inside of it.
+func (d *dumper) term(depth int) error {
+	if err := d.need(1); err != nil {
+		return err
+	}
+	tag := d.data[d.off]
+	name := etf.TagName(tag)
+
+	// header prints a header consisting of the tag followed by size
+	// bytes of fields.
+	header := func(size int, format string, args ...any) error {
+		if err := d.need(1 + size); err != nil {
+			return err
+		}
+		d.line(depth, 1+size, name+format, args...)
+		return nil
+	}
+
+	// sized prints a header containing a length field of the given
+	// size followed by that many bytes of payload.
+	sized := func(size int) error {
+		if err := d.need(1 + size); err != nil {
+			return err
+		}
+		n := d.uint(size)
+		if err := header(size, " len=%d", n); err != nil {
+			return err
+		}
+		if err := d.need(n); err != nil {
+			return err
+		}
+		d.payload(n)
+		return nil
+	}
+
+	// children dumps n nested terms.
+	children := func(n int) error {
+		for i := 0; i < n; i++ {
+			if err := d.term(depth + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch tag {
+	case etf.EtVersion:
+		d.line(depth, 1, "version %d", tag)
+		return nil
+
+	case 'a': // SMALL_INTEGER_EXT
+		if err := d.need(2); err != nil {
+			return err
+		}
+		return header(1, " %d", d.data[d.off+1])
+
+	case 'b': // INTEGER_EXT
+		if err := d.need(5); err != nil {
+			return err
+		}
+		return header(4, " %d", int32(binary.BigEndian.Uint32(d.data[d.off+1:])))
+
+	case 'c': // FLOAT_EXT
+		if err := d.need(32); err != nil {
+			return err
+		}
+		return header(31, " %s", bytes.TrimRight(d.data[d.off+1:d.off+32], "\x00"))
+
+	case 'F': // NEW_FLOAT_EXT
+		if err := d.need(9); err != nil {
+			return err
+		}
+		return header(8, " %v", math.Float64frombits(binary.BigEndian.Uint64(d.data[d.off+1:])))
+
+	case 'd', 'v': // ATOM_EXT, ATOM_UTF8_EXT
+		return d.atom(depth, name, 2)
+
+	case 's', 'w': // SMALL_ATOM_EXT, SMALL_ATOM_UTF8_EXT
+		return d.atom(depth, name, 1)
+
+	case 'k': // STRING_EXT
+		return sized(2)
+
+	case 'm': // BINARY_EXT
+		return sized(4)
+
+	case 'M': // BIT_BINARY_EXT
+		if err := d.need(6); err != nil {
+			return err
+		}
+		n, bits := d.uint(4), d.data[d.off+5]
+		if err := header(5, " len=%d bits=%d", n, bits); err != nil {
+			return err
+		}
+		if err := d.need(n); err != nil {
+			return err
+		}
+		d.payload(n)
+		return nil
+
+	case 'n', 'o': // SMALL_BIG_EXT, LARGE_BIG_EXT
+		size := 1
+		if tag == 'o' {
+			size = 4
+		}
+		if err := d.need(2 + size); err != nil {
+			return err
+		}
+		n, sign := d.uint(size), d.data[d.off+1+size]
+		if err := header(size+1, " len=%d sign=%d", n, sign); err != nil {
+			return err
+		}
+		if err := d.need(n); err != nil {
+			return err
+		}
+		d.payload(n)
+		return nil
+
+	case 'j': // NIL_EXT
+		return header(0, "")
+
+	case 'h', 'i': // SMALL_TUPLE_EXT, LARGE_TUPLE_EXT
+		size := 1
+		if tag == 'i' {
+			size = 4
+		}
+		if err := d.need(1 + size); err != nil {
+			return err
+		}
+		n := d.uint(size)
+		if err := header(size, " arity=%d", n); err != nil {
+			return err
+		}
+		return children(n)
+
+	case 'l': // LIST_EXT
+		if err := d.need(5); err != nil {
+			return err
+		}
+		n := d.uint(4)
+		if err := header(4, " len=%d", n); err != nil {
+			return err
+		}
+		return children(n + 1)
+
+	case 't': // MAP_EXT
+		if err := d.need(5); err != nil {
+			return err
+		}
+		n := d.uint(4)
+		if err := header(4, " arity=%d", n); err != nil {
+			return err
+		}
+		return children(2 * n)
+
+	case 'g', 'X': // PID_EXT, NEW_PID_EXT
+		return d.nodeTerm(depth, name, "id serial creation", 4, 4, creationSize(tag))
+
+	case 'f', 'Y': // PORT_EXT, NEW_PORT_EXT
+		return d.nodeTerm(depth, name, "id creation", 4, creationSize(tag))
+
+	case 'x': // V4_PORT_EXT
+		return d.nodeTerm(depth, name, "id creation", 8, 4)
+
+	case 'e': // REFERENCE_EXT
+		return d.nodeTerm(depth, name, "id creation", 4, 1)
+
+	case 'r', 'Z': // NEW_REFERENCE_EXT, NEWER_REFERENCE_EXT
+		if err := d.need(3); err != nil {
+			return err
+		}
+		n := d.uint(2)
+		if err := header(2, " len=%d", n); err != nil {
+			return err
+		}
+		if err := children(1); err != nil {
+			return err
+		}
+		size := creationSize(tag)
+		if err := d.need(size + 4*n); err != nil {
+			return err
+		}
+		d.line(depth+1, size, "creation")
+		d.line(depth+1, 4*n, "id")
+		return nil
+
+	case 'q': // EXPORT_EXT
+		if err := header(0, ""); err != nil {
+			return err
+		}
+		return children(3)
+
+	case 'p': // NEW_FUN_EXT
+		if err := d.need(30); err != nil {
+			return err
+		}
+		free := int(binary.BigEndian.Uint32(d.data[d.off+26:]))
+		if err := header(29, " arity=%d free=%d", d.data[d.off+5], free); err != nil {
+			return err
+		}
+		return children(4 + free)
+
+	case 'u': // FUN_EXT
+		if err := d.need(5); err != nil {
+			return err
+		}
+		free := d.uint(4)
+		if err := header(4, " free=%d", free); err != nil {
+			return err
+		}
+		return children(4 + free)
+
+	case 'C', 'R': // ATOM_CACHE_REF
+		if err := d.need(2); err != nil {
+			return err
+		}
+		return header(1, " index=%d", d.data[d.off+1])
+
+	case 'y': // LOCAL_EXT
+		if err := header(8, ""); err != nil {
+			return err
+		}
+		return children(1)
+
+	case 'P': // compressed term
+		return d.compressed(depth, name)
+	}
+
+	return fmt.Errorf("offset %d: can't dump tag %s", d.off, name)
+}
+
+func (d *dumper) atom(depth int, name string, size int) error {
+	if err := d.need(1 + size); err != nil {
+		return err
+	}
+	n := d.uint(size)
+	if err := d.need(1 + size + n); err != nil {
+		return err
+	}
+	text := d.data[d.off+1+size : d.off+1+size+n]
+	d.line(depth, 1+size, "%s len=%d %s", name, n, etf.Format(etf.Atom(text)))
+	d.payload(n)
+	return nil
+}
+
+// nodeTerm dumps a term consisting of the tag, a node atom and fields
+// of the given sizes.
+func (d *dumper) nodeTerm(depth int, name, fields string, sizes ...int) error {
+	d.line(depth, 1, "%s", name)
+	if err := d.term(depth + 1); err != nil {
+		return err
+	}
+
+	for i, field := range strings.Fields(fields) {
+		if err := d.need(sizes[i]); err != nil {
+			return err
+		}
+		var v uint64
+		for _, c := range d.data[d.off : d.off+sizes[i]] {
+			v = v<<8 | uint64(c)
+		}
+		d.line(depth+1, sizes[i], "%s=%d", field, v)
+	}
+	return nil
+}
+
+func (d *dumper) compressed(depth int, name string) error {
+	if err := d.need(5); err != nil {
+		return err
+	}
+	size := d.uint(4)
+	d.line(depth, 5, "%s uncompressed=%d", name, size)
+
+	r := bytes.NewReader(d.data[d.off:])
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("offset %d: %w", d.off, err)
+	}
+	inner, err := io.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("offset %d: %w", d.off, err)
+	}
+
+	n := len(d.data) - d.off - r.Len()
+	fmt.Fprintf(d.w, "%08x  %s(%d compressed bytes, contents at relative offsets)\n", d.off, strings.Repeat("  ", depth+1), n)
+	d.off += n
+
+	sub := dumper{w: d.w, data: inner}
+	for sub.off < len(sub.data) {
+		if err := sub.term(depth + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func creationSize(tag byte) int {
+	switch tag {
+	case 'X', 'Y', 'Z':
+		return 4
+	}
+	return 1
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	test := func(in []byte, exp ...string) {
+		var out strings.Builder
+		if err := dump(&out, in); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != len(exp) {
+			t.Fatalf("expected %d lines, got %d:\n%s", len(exp), len(lines), out.String())
+		}
+		for i := range exp {
+			if fields := strings.Join(strings.Fields(lines[i]), " "); fields != exp[i] {
+				t.Errorf("line %d: expected %q, got %q", i, exp[i], fields)
+			}
+		}
+	}
+
+	// {ok, <<"bin">>}
+	test(
+		[]byte{131, 104, 2, 119, 2, 'o', 'k', 109, 0, 0, 0, 3, 'b', 'i', 'n'},
+		"00000000 83 version 131",
+		"00000001 68 02 SMALL_TUPLE_EXT arity=2",
+		"00000003 77 02 SMALL_ATOM_UTF8_EXT len=2 ok",
+		"00000005 6f 6b",
+		"00000007 6d 00 00 00 03 BINARY_EXT len=3",
+		"0000000c 62 69 6e",
+	)
+
+	// <0.38.0> on a@h
+	test(
+		[]byte{103, 115, 3, 'a', '@', 'h', 0, 0, 0, 38, 0, 0, 0, 0, 1},
+		"00000000 67 PID_EXT",
+		"00000001 73 03 SMALL_ATOM_EXT len=3 a@h",
+		"00000003 61 40 68",
+		"00000006 00 00 00 26 id=38",
+		"0000000a 00 00 00 00 serial=0",
+		"0000000e 01 creation=1",
+	)
+
+	// compressed [1]
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte{108, 0, 0, 0, 1, 97, 1, 106})
+	zw.Close()
+	test(
+		append([]byte{80, 0, 0, 0, 8}, z.Bytes()...),
+		"00000000 50 00 00 00 08 COMPRESSED uncompressed=8",
+		fmt.Sprintf("00000005 (%d compressed bytes, contents at relative offsets)", z.Len()),
+		"00000000 6c 00 00 00 01 LIST_EXT len=1",
+		"00000005 61 01 SMALL_INTEGER_EXT 1",
+		"00000007 6a NIL_EXT",
+	)
+}
+
+func TestDumpTruncated(t *testing.T) {
+	var out strings.Builder
+	if err := dump(&out, []byte{104, 2, 97, 1}); err == nil {
+		t.Error("err == nil")
+	}
+}
+
+func TestSplitFrames(t *testing.T) {
+	frames, err := splitFrames([]byte{0, 2, 1, 2, 0, 1, 3}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || !bytes.Equal(frames[0], []byte{1, 2}) || !bytes.Equal(frames[1], []byte{3}) {
+		t.Errorf("unexpected frames %v", frames)
+	}
+
+	if _, err := splitFrames([]byte{0, 0, 0, 5, 1}, 4); err == nil {
+		t.Error("err == nil")
+	}
+}
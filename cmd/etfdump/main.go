@@ -0,0 +1,138 @@
+// etfdump prints the terms in a stream of Erlang external term format
+// data, either in Erlang syntax or as an annotated hex dump.
+//
+// Usage:
+//
+//	etfdump [flags] [file]
+//
+// If no file is given, the data is read from stdin. By default, the
+// input is a sequence of terms, each starting with the version byte,
+// as written by term_to_binary or an Encoder. With -packet, each term
+// is instead expected to be preceded by a big-endian length header of
+// the given size, as with gen_tcp's {packet, N} option.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/DeedleFake/etf"
+)
+
+func main() {
+	packet := flag.Int("packet", 0, "size of the length header of each term (0, 1, 2 or 4)")
+	b64 := flag.Bool("base64", false, "input is base64 encoded")
+	hex := flag.Bool("hex", false, "print an annotated hex dump instead of terms")
+	elixir := flag.Bool("elixir", false, "print terms using Elixir syntax")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [flags] [file]\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(*packet, *b64, *hex, *elixir, flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "etfdump: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(packet int, b64, hex, elixir bool, path string) error {
+	switch packet {
+	case 0, 1, 2, 4:
+	default:
+		return fmt.Errorf("invalid packet size %d", packet)
+	}
+
+	data, err := readInput(path, b64)
+	if err != nil {
+		return err
+	}
+
+	frames := [][]byte{data}
+	if packet != 0 {
+		if frames, err = splitFrames(data, packet); err != nil {
+			return err
+		}
+	}
+
+	for _, frame := range frames {
+		if hex {
+			err = dump(os.Stdout, frame)
+		} else {
+			err = printTerms(os.Stdout, frame, elixir)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readInput(path string, b64 bool) ([]byte, error) {
+	var r io.Reader = os.Stdin
+	if path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !b64 {
+		return data, nil
+	}
+
+	text := strings.Join(strings.Fields(string(data)), "")
+	if decoded, err := base64.StdEncoding.DecodeString(text); err == nil {
+		return decoded, nil
+	}
+	return base64.RawStdEncoding.DecodeString(text)
+}
+
+// splitFrames splits data into frames that are each prefixed with a
+// big-endian length header of the given size.
+func splitFrames(data []byte, size int) ([][]byte, error) {
+	var frames [][]byte
+	for off := 0; off < len(data); {
+		if len(data)-off < size {
+			return nil, fmt.Errorf("offset %d: truncated %d byte length header", off, size)
+		}
+		var n int
+		for _, c := range data[off : off+size] {
+			n = n<<8 | int(c)
+		}
+		off += size
+
+		if len(data)-off < n {
+			return nil, fmt.Errorf("offset %d: frame of %d bytes is truncated to %d", off, n, len(data)-off)
+		}
+		frames = append(frames, data[off:off+n])
+		off += n
+	}
+	return frames, nil
+}
+
+func printTerms(w io.Writer, data []byte, elixir bool) error {
+	format := etf.Format
+	if elixir {
+		format = etf.FormatElixir
+	}
+
+	for term, err := range new(etf.Context).Decoder(bytes.NewReader(data)).All() {
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, format(term))
+	}
+	return nil
+}
@@ -0,0 +1,460 @@
+package etf
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
+)
+
+// Term classes in Erlang's standard order:
+//
+//	number < atom < reference < fun < port < pid < tuple < map < nil < list < bit string
+const (
+	classNumber = iota
+	classAtom
+	classRef
+	classFun
+	classPort
+	classPid
+	classTuple
+	classMap
+	classNil
+	classList
+	classBitstring
+)
+
+// Compare compares two terms using Erlang's standard term order. It
+// returns -1 if a is less than b, 0 if they are equal, and 1 if a is
+// greater than b.
+//
+// Integers and floats are compared by value, so, as with Erlang's ==
+// operator, 1 and 1.0 compare as equal. Go strings are treated as
+// lists of their bytes, which is what STRING_EXT means, Charlist as a
+// list of its code points, and []byte as binaries.
+// Other Go values are interpreted the same way that the Encoder
+// interprets them: structs as tuples of their exported fields and
+// slices and arrays as lists.
+//
+// Compare panics if either argument can't be interpreted as a term.
+func Compare(a, b Term) int {
+	a, b = mustNormalize(a), mustNormalize(b)
+	ca, cb := termClass(a), termClass(b)
+	if ca != cb {
+		return cmp.Compare(ca, cb)
+	}
+
+	switch ca {
+	case classNumber:
+		return compareNumbers(a, b)
+
+	case classAtom:
+		return cmp.Compare(atomText(a), atomText(b))
+
+	case classRef:
+		return compareRefs(a.(Ref), b.(Ref))
+
+	case classFun:
+		return compareFuns(a, b)
+
+	case classPort:
+		a, b := a.(Port), b.(Port)
+		return cmpChain(
+			cmp.Compare(a.Node, b.Node),
+			cmp.Compare(a.Id, b.Id),
+			cmp.Compare(a.Creation, b.Creation),
+		)
+
+	case classPid:
+		a, b := a.(Pid), b.(Pid)
+		return cmpChain(
+			cmp.Compare(a.Node, b.Node),
+			cmp.Compare(a.Serial, b.Serial),
+			cmp.Compare(a.Id, b.Id),
+			cmp.Compare(a.Creation, b.Creation),
+		)
+
+	case classTuple:
+		a, b := a.(Tuple), b.(Tuple)
+		if c := cmp.Compare(len(a), len(b)); c != 0 {
+			return c
+		}
+		return compareElements(a, b)
+
+	case classMap:
+		return compareMaps(a.(Map), b.(Map))
+
+	case classNil:
+		return 0
+
+	case classList:
+		return compareLists(a, b)
+
+	case classBitstring:
+		return bytes.Compare(a.([]byte), b.([]byte))
+	}
+
+	panic("unreachable")
+}
+
+// Equal reports whether a and b are equal according to Compare. Note
+// that, like Erlang's == operator, this considers an integer equal to
+// a float with the same value.
+func Equal(a, b Term) bool {
+	return Compare(a, b) == 0
+}
+
+// ExactEqual reports whether a and b are equal in the sense of Erlang's
+// =:= operator. It is the same as Equal except that integers are never
+// equal to floats, so 1 and 1.0 are different. This is how Erlang
+// matches map keys and patterns.
+func ExactEqual(a, b Term) bool {
+	return Compare(a, b) == 0 && sameNumberKinds(mustNormalize(a), mustNormalize(b))
+}
+
+// sameNumberKinds reports whether every number in a is of the same
+// kind, integer or float, as the number in the same position in b. a
+// and b must already compare as equal.
+func sameNumberKinds(a, b Term) bool {
+	switch termClass(a) {
+	case classNumber:
+		_, _, af := number(a)
+		_, _, bf := number(b)
+		return af == bf
+
+	case classTuple:
+		return sameElementKinds(a.(Tuple), b.(Tuple))
+
+	case classList:
+		ae, at := listParts(a)
+		be, bt := listParts(b)
+		n := min(len(ae), len(be))
+		return sameElementKinds(ae[:n], be[:n]) &&
+			sameNumberKinds(mustNormalize(listRest(ae[n:], at)), mustNormalize(listRest(be[n:], bt)))
+
+	case classMap:
+		a, b := sortedMap(a.(Map)), sortedMap(b.(Map))
+		for i := range a {
+			if !ExactEqual(a[i].Key, b[i].Key) || !ExactEqual(a[i].Value, b[i].Value) {
+				return false
+			}
+		}
+		return true
+
+	case classFun:
+		if a, ok := a.(Function); ok {
+			return sameElementKinds(a.FreeVars, b.(Function).FreeVars)
+		}
+	}
+	return true
+}
+
+func sameElementKinds(a, b []Term) bool {
+	for i := range a {
+		if !sameNumberKinds(mustNormalize(a[i]), mustNormalize(b[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustNormalize(t Term) Term {
+	n, ok := normalize(t)
+	if !ok {
+		panic(fmt.Errorf("compare: can't compare value of type %T", t))
+	}
+	return n
+}
+
+// normalize converts Go values that aren't one of the package's term
+// types into the terms that they would be encoded as. It returns false
+// if t can't be interpreted as a term.
+func normalize(t Term) (Term, bool) {
+	switch t := t.(type) {
+	case bool, string, []byte, Atom, Tuple, List, ImproperList, Map:
+		return t, true
+	case Pid, Port, Ref, Function, Export, *big.Int:
+		return t, true
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uintptr, uint:
+		return t, true
+	case float32, float64:
+		return t, true
+	case Binary:
+		return []byte(t), true
+	case Charlist:
+		elems, _ := listParts(t)
+		return List(elems), true
+	case big.Int:
+		return &t, true
+	}
+
+	rv := reflect.ValueOf(t)
+	switch rv.Kind() {
+	case reflect.Struct:
+		rt := rv.Type()
+		tuple := make(Tuple, 0, rt.NumField())
+		for i := 0; i < rt.NumField(); i++ {
+			field := rt.Field(i)
+			if field.Anonymous || !field.IsExported() {
+				continue
+			}
+			tuple = append(tuple, rv.Field(i).Interface())
+		}
+		return tuple, true
+
+	case reflect.Array, reflect.Slice:
+		list := make(List, rv.Len())
+		for i := range list {
+			list[i] = rv.Index(i).Interface()
+		}
+		return list, true
+
+	case reflect.Ptr:
+		if !rv.IsNil() {
+			return normalize(rv.Elem().Interface())
+		}
+	}
+
+	return nil, false
+}
+
+func termClass(t Term) int {
+	switch t := t.(type) {
+	case bool, Atom:
+		return classAtom
+	case Ref:
+		return classRef
+	case Function, Export:
+		return classFun
+	case Port:
+		return classPort
+	case Pid:
+		return classPid
+	case Tuple:
+		return classTuple
+	case Map:
+		return classMap
+	case ImproperList:
+		return classList
+	case List:
+		if len(t) == 0 {
+			return classNil
+		}
+		return classList
+	case string:
+		if len(t) == 0 {
+			return classNil
+		}
+		return classList
+	case []byte:
+		return classBitstring
+	}
+	return classNumber
+}
+
+func atomText(t Term) string {
+	switch t := t.(type) {
+	case bool:
+		if t {
+			return "true"
+		}
+		return "false"
+	case Atom:
+		return string(t)
+	}
+	panic("unreachable")
+}
+
+// listParts returns the elements and the tail of a list term. Strings
+// are returned as lists of their bytes and charlists as lists of their
+// code points.
+func listParts(t Term) (elems []Term, tail Term) {
+	switch t := t.(type) {
+	case List:
+		return t, List{}
+	case ImproperList:
+		return t.Elements, t.Tail
+	case string:
+		list := make([]Term, len(t))
+		for i := range list {
+			list[i] = int(t[i])
+		}
+		return list, List{}
+	case Charlist:
+		list := make([]Term, 0, len(t))
+		for _, c := range t {
+			list = append(list, int(c))
+		}
+		return list, List{}
+	}
+	panic("unreachable")
+}
+
+// compareLists compares two lists cell by cell, so that improper
+// tails are compared against whatever is in the same position in the
+// other list.
+func compareLists(a, b Term) int {
+	ae, at := listParts(a)
+	be, bt := listParts(b)
+	if c := compareElements(ae, be); c != 0 {
+		return c
+	}
+
+	n := min(len(ae), len(be))
+	return Compare(listRest(ae[n:], at), listRest(be[n:], bt))
+}
+
+func listRest(elems []Term, tail Term) Term {
+	if len(elems) == 0 {
+		return tail
+	}
+	if termClass(tail) == classNil {
+		return List(elems)
+	}
+	return ImproperList{elems, tail}
+}
+
+// compareMaps compares maps by size, then by their keys in order, and
+// then by their values in key order.
+func compareMaps(a, b Map) int {
+	if c := cmp.Compare(len(a), len(b)); c != 0 {
+		return c
+	}
+
+	a, b = sortedMap(a), sortedMap(b)
+	for i := range a {
+		if c := Compare(a[i].Key, b[i].Key); c != 0 {
+			return c
+		}
+	}
+	for i := range a {
+		if c := Compare(a[i].Value, b[i].Value); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func sortedMap(m Map) Map {
+	m = slices.Clone(m)
+	slices.SortFunc(m, func(a, b MapEntry) int { return Compare(a.Key, b.Key) })
+	return m
+}
+
+func compareElements(a, b []Term) int {
+	for i := 0; i < min(len(a), len(b)); i++ {
+		if c := Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareNumbers(a, b Term) int {
+	ai, af, aIsFloat := number(a)
+	bi, bf, bIsFloat := number(b)
+
+	switch {
+	case !aIsFloat && !bIsFloat:
+		return ai.Cmp(bi)
+	case aIsFloat && bIsFloat:
+		return cmp.Compare(af, bf)
+	case aIsFloat:
+		return -compareIntFloat(bi, af)
+	default:
+		return compareIntFloat(ai, bf)
+	}
+}
+
+// compareIntFloat compares an integer with a float exactly, without
+// losing precision on large integers.
+func compareIntFloat(i *big.Int, f float64) int {
+	if math.IsNaN(f) {
+		return 1
+	}
+	return new(big.Float).SetInt(i).Cmp(big.NewFloat(f))
+}
+
+// number returns either the integer or the float value of a numeric
+// term.
+func number(t Term) (i *big.Int, f float64, isFloat bool) {
+	switch t := t.(type) {
+	case float32:
+		return nil, float64(t), true
+	case float64:
+		return nil, t, true
+	case *big.Int:
+		return t, 0, false
+	case uint8, uint16, uint32, uint64, uintptr, uint:
+		return new(big.Int).SetUint64(reflect.ValueOf(t).Uint()), 0, false
+	case int8, int16, int32, int64, int:
+		return big.NewInt(reflect.ValueOf(t).Int()), 0, false
+	}
+	panic("unreachable")
+}
+
+func compareRefs(a, b Ref) int {
+	if c := cmp.Compare(a.Node, b.Node); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(len(a.Id), len(b.Id)); c != 0 {
+		return c
+	}
+	// The first ID word is the least significant.
+	for i := len(a.Id) - 1; i >= 0; i-- {
+		if c := cmp.Compare(a.Id[i], b.Id[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.Creation, b.Creation)
+}
+
+// compareFuns compares two funs. Local funs sort before external funs
+// and each kind is ordered by module first.
+func compareFuns(a, b Term) int {
+	switch a := a.(type) {
+	case Function:
+		b, ok := b.(Function)
+		if !ok {
+			return -1
+		}
+		if c := cmpChain(
+			cmp.Compare(a.Module, b.Module),
+			cmp.Compare(a.OldIndex, b.OldIndex),
+			cmp.Compare(a.OldUnique, b.OldUnique),
+			cmp.Compare(a.Index, b.Index),
+			bytes.Compare(a.Unique[:], b.Unique[:]),
+			cmp.Compare(a.Arity, b.Arity),
+			Compare(a.Pid, b.Pid),
+			cmp.Compare(len(a.FreeVars), len(b.FreeVars)),
+		); c != 0 {
+			return c
+		}
+		return compareElements(a.FreeVars, b.FreeVars)
+
+	case Export:
+		b, ok := b.(Export)
+		if !ok {
+			return 1
+		}
+		return cmpChain(
+			cmp.Compare(a.Module, b.Module),
+			cmp.Compare(a.Function, b.Function),
+			cmp.Compare(a.Arity, b.Arity),
+		)
+	}
+	panic("unreachable")
+}
+
+// cmpChain returns the first non-zero comparison result.
+func cmpChain(results ...int) int {
+	for _, c := range results {
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
@@ -0,0 +1,134 @@
+package etf
+
+import (
+	"math"
+	"math/big"
+	"slices"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	test := func(a, b Term, exp int) {
+		if v := Compare(a, b); v != exp {
+			t.Errorf("Compare(%v, %v): expected %v, got %v", a, b, exp, v)
+		}
+		if v := Compare(b, a); v != -exp {
+			t.Errorf("Compare(%v, %v): expected %v, got %v", b, a, -exp, v)
+		}
+	}
+
+	big1 := new(big.Int).Lsh(big.NewInt(1), 100)
+
+	// numbers
+	test(1, 2, -1)
+	test(1, 1.0, 0)
+	test(int8(3), uint64(3), 0)
+	test(2.5, 2, 1)
+	test(big1, int64(math.MaxInt64), 1)
+	test(big1, math.Pow(2, 100), 0)
+	test(new(big.Int).Neg(big1), -1.0, -1)
+
+	// classes
+	test(1, Atom("a"), -1)
+	test(Atom("a"), Ref{}, -1)
+	test(Ref{}, Export{}, -1)
+	test(Function{}, Port{}, -1)
+	test(Port{}, Pid{}, -1)
+	test(Pid{}, Tuple{}, -1)
+	test(Tuple{}, List{}, -1)
+	test(List{}, List{1}, -1)
+	test(List{1}, []byte{}, -1)
+
+	// atoms
+	test(Atom("abc"), Atom("abd"), -1)
+	test(true, Atom("true"), 0)
+	test(false, true, -1)
+
+	// tuples are ordered by size first
+	test(Tuple{2}, Tuple{1, 1}, -1)
+	test(Tuple{1, Atom("a")}, Tuple{1, Atom("b")}, -1)
+
+	// lists are ordered element by element
+	test(List{1, 2}, List{1, 2, 3}, -1)
+	test(List{2}, List{1, 2, 3}, 1)
+	test("abc", List{97, 98, 99}, 0)
+	test("", List{}, 0)
+
+	// binaries
+	test([]byte("abc"), []byte("abd"), -1)
+	test([]byte("ab"), []byte("abc"), -1)
+
+	// pids, ports and refs
+	test(Pid{Atom("a@h"), 1, 0, 0}, Pid{Atom("a@h"), 2, 0, 0}, -1)
+	test(Pid{Atom("a@h"), 9, 0, 0}, Pid{Atom("b@h"), 1, 0, 0}, -1)
+	test(Port{Atom("a@h"), 5, 0}, Port{Atom("a@h"), 5, 0}, 0)
+	test(Ref{Atom("a@h"), 0, []uint32{2, 1}}, Ref{Atom("a@h"), 0, []uint32{1, 2}}, -1)
+
+	// funs
+	test(Function{Module: Atom("m")}, Export{Atom("a"), Atom("f"), 0}, -1)
+	test(Export{Atom("m"), Atom("f"), 1}, Export{Atom("m"), Atom("f"), 2}, -1)
+
+	// Go values are compared as they would be encoded
+	type rec struct {
+		A Atom
+		N int
+	}
+	test(rec{Atom("ok"), 1}, Tuple{Atom("ok"), 1}, 0)
+	test(&rec{Atom("ok"), 1}, Tuple{Atom("ok"), 2}, -1)
+	test([]int{1, 2}, List{1, 2}, 0)
+}
+
+func TestCompareSort(t *testing.T) {
+	in := []Term{
+		[]byte("bin"),
+		List{1},
+		List{},
+		Tuple{Atom("ok")},
+		Pid{Atom("a@h"), 1, 0, 0},
+		Port{Atom("a@h"), 1, 0},
+		Export{Atom("m"), Atom("f"), 0},
+		Ref{Atom("a@h"), 0, []uint32{1}},
+		Atom("atom"),
+		1.5,
+		1,
+	}
+	exp := slices.Clone(in)
+	slices.Reverse(exp)
+
+	slices.SortFunc(in, Compare)
+	for i := range in {
+		if !Equal(in[i], exp[i]) {
+			t.Errorf("%d: expected %v, got %v", i, exp[i], in[i])
+		}
+	}
+}
+
+func TestCompareUnknown(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	Compare(make(chan int), 1)
+}
+
+func TestExactEqual(t *testing.T) {
+	tests := []struct {
+		a, b Term
+		exp  bool
+	}{
+		{1, int64(1), true},
+		{1, 1.0, false},
+		{Tuple{Atom("ok"), 1}, Tuple{Atom("ok"), 1.0}, false},
+		{List{1, 2}, "\x01\x02", true},
+		{ImproperList{List{1}, 2}, ImproperList{List{1}, 2.0}, false},
+		{Map{{1, Atom("a")}}, Map{{1.0, Atom("a")}}, false},
+		{Map{{Atom("k"), 2}}, Map{{Atom("k"), 2}}, true},
+		{Atom("a"), Atom("b"), false},
+	}
+	for _, test := range tests {
+		if v := ExactEqual(test.a, test.b); v != test.exp {
+			t.Errorf("ExactEqual(%v, %v): expected %v, got %v", test.a, test.b, test.exp, v)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package etf
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// corpus lists the files in testdata/corpus, which hold the encoding
+// of the term in each entry. testdata/corpus.escript regenerates the
+// files that term_to_binary produces in current releases. Until it has
+// been run with OTP 26 or later, those files are written from the
+// specification, not taken from a VM; in particular, the compressed
+// files were produced with Go's zlib, so only decoding them is tested.
+//
+// Some files can't come from the script. Funs made in an escript are
+// interpreted by erl_eval, so their module, index and free variables
+// depend on the release, and the hash of LOCAL_EXT depends on the node
+// that made it. new_fun and local_pid are written from the
+// specification instead, and the legacy entries are in encodings that
+// only older releases produce, such as FUN_EXT, which OTP 23 removed.
+//
+// Each file must decode to exactly term. Unless decodeOnly is set,
+// encoding the decoded term with opts must also reproduce the file
+// byte for byte. It is set for encodings that the Encoder never
+// produces: FLOAT_EXT, LOCAL_EXT, bitstrings, which decode as their
+// bytes, compressed terms and the legacy encodings that TargetOTP
+// doesn't select.
+var corpus = []struct {
+	name       string
+	term       Term
+	opts       []EncoderOption
+	decodeOnly bool
+}{
+	{name: "atom_ok", term: Atom("ok")},
+	{name: "atom_empty", term: Atom("")},
+	{name: "atom_true", term: true},
+	{name: "atom_255", term: Atom(strings.Repeat("a", 255))},
+	{name: "atom_utf8", term: Atom("héllo")},
+	{name: "atom_utf8_long", term: Atom(strings.Repeat("é", 255))},
+	{name: "atom_latin1", term: Atom("ok"), opts: latin1Atoms},
+	{name: "atom_latin1_accent", term: Atom("héllo"), opts: latin1Atoms},
+	// Latin1Atoms is for releases before OTP 20, which can't decode
+	// UTF-8 atoms at all, so it doesn't fall back to them like
+	// term_to_binary with {minor_version, 1} does.
+	{name: "atom_latin1_unicode", term: Atom("日本"), decodeOnly: true},
+
+	{name: "int_0", term: 0},
+	{name: "int_255", term: 255},
+	{name: "int_256", term: 256},
+	{name: "int_neg1", term: -1},
+	{name: "int_min32", term: -1 << 31},
+	{name: "int_max32", term: 1<<31 - 1},
+	{name: "int_2p31", term: int64(1 << 31)},
+	{name: "int_neg_2p31_1", term: int64(-1<<31 - 1)},
+	{name: "int_2p64", term: pow2(64)},
+	{name: "int_small_big_max", term: new(big.Int).Sub(pow2(2040), big.NewInt(1))},
+	{name: "int_large_big", term: pow2(2040)},
+	{name: "int_neg_large_big", term: new(big.Int).Neg(pow2(2040))},
+
+	{name: "float_1_5", term: 1.5},
+	{name: "float_0_1", term: 0.1},
+	{name: "float_neg", term: -2.5},
+	{name: "float_1e300", term: 1e300},
+	{name: "float_old", term: 1.5, decodeOnly: true},
+
+	{name: "string_empty", term: List{}},
+	{name: "string_abc", term: "abc"},
+	{name: "string_latin1", term: "h\xe9llo"},
+	{name: "string_65535", term: strings.Repeat("a", 65535)},
+	{name: "string_65536", term: slices.Repeat(List{97}, 65536)},
+	{name: "string_unicode", term: List{0x65e5, 0x672c}},
+
+	{name: "list_mixed", term: List{1, Atom("ok"), "a"}},
+	{name: "list_improper", term: ImproperList{List{1}, 2}},
+	{name: "list_nested", term: List{List{}}},
+	{name: "list_large_ints", term: List{256, -1}},
+
+	{name: "tuple_empty", term: Tuple{}},
+	{name: "tuple_pair", term: Tuple{Atom("ok"), 1}},
+	{name: "tuple_255", term: slices.Repeat(Tuple{0}, 255)},
+	{name: "tuple_256", term: slices.Repeat(Tuple{0}, 256)},
+
+	{name: "binary_empty", term: []byte{}},
+	{name: "binary_bytes", term: []byte{0, 1, 255}},
+	{name: "binary_utf8", term: []byte("héllo")},
+
+	{name: "map_empty", term: Map{}},
+	{name: "map_small", term: Map{{Atom("a"), 1}, {Atom("b"), []byte("x")}}},
+	{name: "map_mixed_keys", term: Map{{1, Atom("a")}, {Atom("b"), 2}, {"s", 3}}},
+	{name: "map_32", term: intMap(32)},
+	{name: "map_33_deterministic", term: intMap(33)},
+
+	{name: "nested", term: Tuple{
+		Atom("reply"),
+		List{Map{{Atom("k"), Tuple{1.5, []byte("b")}}}},
+		"str",
+	}},
+
+	{name: "pid", term: Pid{Node: "a@host", Id: 85, Creation: 0x12345678}},
+	{name: "port", term: Port{Node: "a@host", Id: 258, Creation: 0x12345678}},
+	{name: "port_v4", term: Port{Node: "a@host", Id: 1 << 40, Creation: 0x12345678}},
+	{name: "ref", term: Ref{Node: "a@host", Creation: 0x12345678, Id: []uint32{1, 2, 3}}},
+	{name: "export", term: Export{Module: "lists", Function: "reverse", Arity: 1}},
+	{name: "new_fun", term: Function{
+		Arity:     1,
+		Unique:    [16]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		Index:     0,
+		Free:      1,
+		Module:    "m",
+		OldIndex:  0,
+		OldUnique: 12345678,
+		Pid:       Pid{Node: "a@host", Id: 85, Creation: 0x12345678},
+		FreeVars:  []Term{42},
+	}},
+	{name: "local_pid", term: Pid{Node: "a@host", Id: 85, Creation: 0x12345678}, decodeOnly: true},
+
+	// The trailing bits of a bitstring are returned as the low bits of
+	// its last byte, so <<1:3>> decodes to <<1>>.
+	{name: "bitstring", term: []byte{1}, decodeOnly: true},
+	{name: "bitstring_bytes", term: []byte{255, 5}, decodeOnly: true},
+
+	{name: "compressed_string", term: strings.Repeat("\x00", 1000), decodeOnly: true},
+	{name: "compressed_tuple", term: slices.Repeat(Tuple{Atom("hello")}, 100), decodeOnly: true},
+	{name: "compressed_skipped", term: Atom("ok")},
+
+	{name: "legacy_atom_ext", term: Atom("abc"), decodeOnly: true},
+	{name: "legacy_pid", term: Pid{Node: "a@host", Id: 85, Creation: 1}, opts: otp22},
+	{name: "legacy_port", term: Port{Node: "a@host", Id: 258, Creation: 1}, opts: otp22},
+	{name: "legacy_new_ref", term: Ref{Node: "a@host", Creation: 1, Id: []uint32{1, 2, 3}}, opts: otp22},
+	{name: "legacy_ref", term: Ref{Node: "a@host", Creation: 1, Id: []uint32{7}}, decodeOnly: true},
+	{name: "legacy_new_fun", term: Function{
+		Arity:     1,
+		Unique:    [16]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		Index:     0,
+		Free:      1,
+		Module:    "m",
+		OldIndex:  0,
+		OldUnique: 12345678,
+		Pid:       Pid{Node: "a@host", Id: 85, Creation: 0x12345678},
+		FreeVars:  []Term{42},
+	}, decodeOnly: true},
+	{name: "legacy_fun", term: Function{
+		Free:      1,
+		Module:    "m",
+		OldIndex:  0,
+		OldUnique: 12345678,
+		Pid:       Pid{Node: "a@host", Id: 85, Creation: 1},
+		FreeVars:  []Term{42},
+	}, decodeOnly: true},
+}
+
+var (
+	latin1Atoms = []EncoderOption{EncodeLatin1Atoms(true)}
+	otp22       = []EncoderOption{TargetOTP(22), EncodeLatin1Atoms(true)}
+)
+
+func pow2(n uint) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), n)
+}
+
+func intMap(n int) Map {
+	m := make(Map, n)
+	for i := range m {
+		m[i] = MapEntry{i + 1, i + 1}
+	}
+	return m
+}
+
+func TestCorpus(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "corpus", "*.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool, len(files))
+	for _, file := range files {
+		names[strings.TrimSuffix(filepath.Base(file), ".bin")] = true
+	}
+
+	for _, test := range corpus {
+		if !names[test.name] {
+			t.Errorf("%v: missing file", test.name)
+			continue
+		}
+		delete(names, test.name)
+
+		t.Run(test.name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "corpus", test.name+".bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d := new(Context).Decoder(bytes.NewReader(data), DecodeStrict(true))
+			term, err := d.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := d.Decode(); err != io.EOF {
+				t.Fatalf("expected a single term, got %v", err)
+			}
+			if !ExactEqual(term, test.term) {
+				t.Fatalf("expected %v, got %v", Format(test.term), Format(term))
+			}
+
+			if test.decodeOnly {
+				return
+			}
+			var buf bytes.Buffer
+			if err := new(Context).Encoder(&buf, test.opts...).Encode(term); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Fatalf("round trip mismatch\nexpected % x\ngot      % x", head(data), head(buf.Bytes()))
+			}
+		})
+	}
+
+	for name := range names {
+		t.Errorf("%v: file isn't in the corpus table", name)
+	}
+}
+
+// head returns the start of b, for error messages about large terms.
+func head(b []byte) []byte {
+	return b[:min(len(b), 64)]
+}
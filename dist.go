@@ -0,0 +1,150 @@
+package etf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Distribution control message operations.
+const (
+	DistLink         = 1
+	DistSend         = 2
+	DistExit         = 3
+	DistUnlink       = 4
+	DistRegSend      = 6
+	DistMonitorP     = 19
+	DistDemonitorP   = 20
+	DistMonitorPExit = 21
+	DistSendSender   = 22
+	DistSpawnRequest = 29
+	DistSpawnReply   = 31
+)
+
+// Distribution flags, which the handshake uses to negotiate the
+// capabilities of a connection.
+const (
+	// DistFlagSpawn means that the node understands SPAWN_REQUEST and
+	// SPAWN_REPLY, which spawn_request/5 and erpc use.
+	DistFlagSpawn uint64 = 1 << 32
+)
+
+// distPassThrough is the byte that starts a distribution message that
+// doesn't use the distribution header and atom cache.
+const distPassThrough = 'p'
+
+// DistConn sends and receives the messages of the Erlang distribution
+// protocol over a connection to another node. The handshake must
+// already have been done by something else, and the connection must
+// not have negotiated DFLAG_DIST_HDR_ATOM_CACHE or DFLAG_FRAGMENTS, so
+// that messages use the pass-through format: a 4 byte length, the byte
+// 'p', the control message and, for messages that carry one, the
+// message itself, each with their own version byte.
+//
+// DistConn doesn't send ticks on its own. Call Tick often enough to
+// keep the other node from disconnecting.
+type DistConn struct {
+	// Flags are the distribution flags that the handshake negotiated
+	// with the other node, such as DistFlagSpawn. They select the
+	// features that are used with it.
+	Flags uint64
+
+	fr *FramedReader
+
+	wm  sync.Mutex
+	w   io.Writer
+	buf bytes.Buffer
+	e   *Encoder
+}
+
+// NewDistConn returns a DistConn that communicates over rw, reading
+// control messages and messages with a Decoder and writing them with
+// an Encoder with the given options. Both use a new Context.
+func NewDistConn(rw io.ReadWriter, opts ...EncoderOption) *DistConn {
+	return new(Context).DistConn(rw, opts...)
+}
+
+// DistConn is like NewDistConn, but the Decoder and the Encoder use c,
+// so that its atom cache can be shared with other connections.
+func (c *Context) DistConn(rw io.ReadWriter, opts ...EncoderOption) *DistConn {
+	conn := &DistConn{
+		fr: c.FramedReader(rw, 4),
+		w:  rw,
+	}
+	conn.e = c.Encoder(&conn.buf, opts...)
+	return conn
+}
+
+// ReadMessage reads the next distribution message, skipping ticks.
+// msg is nil if the control message doesn't carry a message.
+func (c *DistConn) ReadMessage() (control Tuple, msg Term, err error) {
+	frame, err := c.fr.readFrame()
+	if err != nil {
+		return nil, nil, err
+	}
+	if frame[0] != distPassThrough {
+		return nil, nil, fmt.Errorf("dist: unsupported message type %d", frame[0])
+	}
+
+	f := c.fr
+	f.frame.Reset(frame[1:])
+	f.d.Reset(&f.frame)
+
+	t, err := f.d.Decode()
+	if err != nil {
+		return nil, nil, err
+	}
+	control, ok := t.(Tuple)
+	if !ok || len(control) == 0 {
+		return nil, nil, fmt.Errorf("dist: bad control message %s", Format(t))
+	}
+
+	if f.left() != 0 {
+		if msg, err = f.d.Decode(); err != nil {
+			return nil, nil, err
+		}
+		if n := f.left(); n != 0 {
+			return nil, nil, fmt.Errorf("dist: %d bytes left after message", n)
+		}
+	}
+	return control, msg, nil
+}
+
+// WriteMessage writes a distribution message made of the control
+// message and, if it is given, the message that it carries. It is safe
+// to call concurrently with other writes.
+func (c *DistConn) WriteMessage(control Tuple, msg ...Term) error {
+	if len(msg) > 1 {
+		panic("dist: more than one message")
+	}
+
+	c.wm.Lock()
+	defer c.wm.Unlock()
+
+	c.buf.Reset()
+	c.buf.Write([]byte{0, 0, 0, 0, distPassThrough})
+	if err := c.e.Encode(control); err != nil {
+		return err
+	}
+	for _, m := range msg {
+		if err := c.e.Encode(m); err != nil {
+			return err
+		}
+	}
+
+	b := c.buf.Bytes()
+	size := len(b) - 4
+	b[0], b[1], b[2], b[3] = byte(size>>24), byte(size>>16), byte(size>>8), byte(size)
+	_, err := c.w.Write(b)
+	return err
+}
+
+// Tick writes an empty message, which keeps the connection alive.
+func (c *DistConn) Tick() error {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+
+	_, err := c.w.Write([]byte{0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,65 @@
+package etf
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func TestDistConn(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := NewDistConn(readWriter{buf, buf})
+
+	to := Pid{Node: "a@h", Id: 1}
+	if err := c.WriteMessage(Tuple{DistSend, Atom(""), to}, Atom("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteMessage(Tuple{DistLink, to, to}); err != nil {
+		t.Fatal(err)
+	}
+
+	if start := buf.Bytes()[:8]; !bytes.Equal(start, []byte{0, 0, 0, 31, 'p', 131, 104, 3}) {
+		t.Errorf("unexpected frame start % x", start)
+	}
+
+	control, msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(control, Tuple{DistSend, Atom(""), to}) || !Equal(msg, Atom("hi")) {
+		t.Errorf("unexpected message %v, %v", control, msg)
+	}
+
+	control, msg, err = c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(control, Tuple{DistLink, to, to}) || msg != nil {
+		t.Errorf("unexpected message %v, %v", control, msg)
+	}
+
+	if _, _, err := c.ReadMessage(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestDistConnBad(t *testing.T) {
+	for _, in := range [][]byte{
+		{0, 0, 0, 3, 'q', 131, 106},
+		{0, 0, 0, 3, 'p', 131, 106},
+		{0, 0, 0, 7, 'p', 131, 104, 1, 97, 2, 0},
+	} {
+		c := NewDistConn(readWriter{bytes.NewReader(in), io.Discard})
+		if _, _, err := c.ReadMessage(); err == nil {
+			t.Errorf("% x: expected error", in)
+		}
+	}
+}
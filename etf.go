@@ -5,31 +5,58 @@ import (
 	"fmt"
 	"io"
 	"slices"
+	"sync"
 )
 
-type cacheFlag struct {
-	isNew      bool
-	segmentIdx uint8
+// AtomCacheSize is the number of entries in a Context's atom cache.
+const AtomCacheSize = 2048
+
+// Context stores globally useful information that can carry between
+// reads and writes, such as caching of atoms. It is safe for
+// concurrent use by multiple Encoders and Decoders, but each Encoder
+// and Decoder must only be used by one goroutine at a time.
+type Context struct {
+	m         sync.RWMutex
+	atomCache [AtomCacheSize]*Atom
 }
 
-type atomCacheRef struct {
-	idx  uint8
-	text *string
+// CacheAtom stores an atom in the atom cache at the given index, as
+// when a distribution header contains a new atom cache entry. The
+// index must be less than AtomCacheSize.
+func (c *Context) CacheAtom(index int, atom Atom) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.atomCache[index] = &atom
 }
 
-// Context stores globally useful information that can carry between
-// reads and writes, such as caching of atoms.
-type Context struct {
-	atomCache    [2048]*string
-	currentCache []*string
+// CachedAtom returns the atom in the atom cache at the given index, and
+// whether there is one.
+func (c *Context) CachedAtom(index int) (Atom, bool) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	if index < 0 || index >= len(c.atomCache) || c.atomCache[index] == nil {
+		return "", false
+	}
+	return *c.atomCache[index], true
 }
 
-func (c *Context) Decoder(r io.Reader) *Decoder {
-	return &Decoder{c: c, r: bufio.NewReader(r)}
+func (c *Context) Decoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	d := &Decoder{c: c, cr: countingReader{r: r}}
+	d.r = bufio.NewReader(&d.cr)
+	for _, opt := range opts {
+		opt(&d.opts)
+	}
+	return d
 }
 
-func (c *Context) Encoder(w io.Writer) *Encoder {
-	return &Encoder{c: c, w: w}
+func (c *Context) Encoder(w io.Writer, opts ...EncoderOption) *Encoder {
+	e := &Encoder{c: c, w: w}
+	for _, opt := range opts {
+		opt(&e.opts)
+	}
+	return e
 }
 
 type Term any
@@ -37,22 +64,51 @@ type Tuple []Term
 type List []Term
 type Atom string
 
+// Binary is a binary. The Decoder produces it instead of []byte when
+// configured with DecodeBinariesAs(BinaryAsBinary).
+type Binary []byte
+
+// Charlist is an Erlang string, which is a list of Unicode code
+// points, held as the UTF-8 encoding of those code points. The Decoder
+// produces it for printable lists when configured with
+// DetectCharlists(true). Unlike a Go string, which holds the bytes of
+// a STRING_EXT, a Charlist compares, formats and encodes as the list
+// of its code points, so Charlist("hé€") is the same term as
+// List{104, 233, 8364}.
+type Charlist string
+
+// ImproperList is a list whose tail is something other than an empty
+// list, such as [1, 2 | 3].
+type ImproperList struct {
+	Elements List
+	Tail     Term
+}
+
+// Map is an Erlang map. Because keys can be terms that aren't
+// comparable in Go, such as tuples, it is stored as a list of entries.
+type Map []MapEntry
+
+type MapEntry struct {
+	Key   Term
+	Value Term
+}
+
 type Pid struct {
 	Node     Atom
 	Id       uint32
 	Serial   uint32
-	Creation byte
+	Creation uint32
 }
 
 type Port struct {
 	Node     Atom
-	Id       uint32
-	Creation byte
+	Id       uint64
+	Creation uint32
 }
 
 type Ref struct {
 	Node     Atom
-	Creation byte
+	Creation uint32
 	Id       []uint32
 }
 
@@ -82,6 +138,7 @@ const (
 	ettBitBinary     = 'M'
 	ettCachedAtom    = 'C'
 	ettCacheRef      = 'R'
+	ettCompressed    = 'P'
 	ettExport        = 'q'
 	ettFloat         = 'c'
 	ettFun           = 'u'
@@ -89,10 +146,15 @@ const (
 	ettLargeBig      = 'o'
 	ettLargeTuple    = 'i'
 	ettList          = 'l'
+	ettLocal         = 'y'
+	ettMap           = 't'
 	ettNewCache      = 'N'
 	ettNewFloat      = 'F'
 	ettNewFun        = 'p'
+	ettNewPid        = 'X'
+	ettNewPort       = 'Y'
 	ettNewRef        = 'r'
+	ettNewerRef      = 'Z'
 	ettNil           = 'j'
 	ettPid           = 'g'
 	ettPort          = 'f'
@@ -103,6 +165,7 @@ const (
 	ettSmallInteger  = 'a'
 	ettSmallTuple    = 'h'
 	ettString        = 'k'
+	ettV4Port        = 'x'
 )
 
 const (
@@ -115,7 +178,8 @@ var tagNames = map[byte]string{
 	ettAtomUTF8:      "ATOM_UTF8_EXT",
 	ettBinary:        "BINARY_EXT",
 	ettBitBinary:     "BIT_BINARY_EXT",
-	ettCachedAtom:    "ATOM_CACHE_REF",
+	ettCacheRef:      "ATOM_CACHE_REF",
+	ettCompressed:    "COMPRESSED",
 	ettExport:        "EXPORT_EXT",
 	ettFloat:         "FLOAT_EXT",
 	ettFun:           "FUN_EXT",
@@ -123,10 +187,15 @@ var tagNames = map[byte]string{
 	ettLargeBig:      "LARGE_BIG_EXT",
 	ettLargeTuple:    "LARGE_TUPLE_EXT",
 	ettList:          "LIST_EXT",
+	ettLocal:         "LOCAL_EXT",
+	ettMap:           "MAP_EXT",
 	ettNewCache:      "NEW_CACHE_EXT",
 	ettNewFloat:      "NEW_FLOAT_EXT",
 	ettNewFun:        "NEW_FUN_EXT",
+	ettNewPid:        "NEW_PID_EXT",
+	ettNewPort:       "NEW_PORT_EXT",
 	ettNewRef:        "NEW_REFERENCE_EXT",
+	ettNewerRef:      "NEWER_REFERENCE_EXT",
 	ettNil:           "NIL_EXT",
 	ettPid:           "PID_EXT",
 	ettPort:          "PORT_EXT",
@@ -137,10 +206,24 @@ var tagNames = map[byte]string{
 	ettSmallInteger:  "SMALL_INTEGER_EXT",
 	ettSmallTuple:    "SMALL_TUPLE_EXT",
 	ettString:        "STRING_EXT",
+	ettV4Port:        "V4_PORT_EXT",
 }
 
+// Element returns the ith element of t, counting from 1 as Erlang's
+// element/2 does. It panics if i is out of range. Use Get or TupleGet
+// to check the index instead.
 func (t Tuple) Element(i int) Term {
-	return t[i-1]
+	e, ok := t.Get(i)
+	if !ok {
+		panic(fmt.Errorf("element %d of %d-tuple is out of range", i, len(t)))
+	}
+	return e
+}
+
+// TagName returns the name of an external term format tag, such as
+// "SMALL_TUPLE_EXT", or its number if it isn't a known tag.
+func TagName(t byte) string {
+	return tagName(t)
 }
 
 func tagName(t byte) (name string) {
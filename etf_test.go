@@ -0,0 +1,75 @@
+package etf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestContextAtomCache(t *testing.T) {
+	c := new(Context)
+	c.CacheAtom(5, "hello")
+	c.CacheAtom(6, "true")
+
+	d := c.Decoder(bytes.NewReader([]byte{ettCacheRef, 0, ettCacheRef, 1, ettCacheRef, 2}))
+	d.SetAtomCacheRefs([]int{5, 6})
+	if v, err := d.Decode(); err != nil || v != Atom("hello") {
+		t.Errorf("expected hello, got %v (%v)", v, err)
+	}
+	if v, err := d.Decode(); err != nil || v != true {
+		t.Errorf("expected true, got %v (%v)", v, err)
+	}
+	_, err := d.Decode()
+	if err == nil {
+		t.Error("err == nil for reference out of range")
+	} else if strings.Count(err.Error(), "read: ") != 1 {
+		t.Errorf("repeated prefix in %q", err)
+	} else if !strings.Contains(err.Error(), "(ATOM_CACHE_REF)") {
+		t.Errorf("expected the tag name in %q", err)
+	}
+
+	if _, ok := c.CachedAtom(7); ok {
+		t.Error("entry 7 should be empty")
+	}
+}
+
+func TestContextConcurrent(t *testing.T) {
+	c := new(Context)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 100; i++ {
+				index := (g*100 + i) % AtomCacheSize
+				atom := Atom(fmt.Sprintf("atom%d", index))
+				c.CacheAtom(index, atom)
+
+				in := Tuple{atom, i, "text", []byte{1, 2, 3}, Pid{atom, 1, 2, 3}}
+				w := new(bytes.Buffer)
+				if err := c.Encoder(w).Encode(in); err != nil {
+					t.Error(err)
+					return
+				}
+
+				d := c.Decoder(bytes.NewReader(append(w.Bytes(), ettCacheRef, 0)))
+				d.SetAtomCacheRefs([]int{index})
+				if v, err := d.Decode(); err != nil {
+					t.Error(err)
+				} else if !Equal(v, in) {
+					t.Errorf("expected %v, got %v", in, v)
+				}
+				if v, err := d.Decode(); err != nil {
+					t.Error(err)
+				} else if v != atom {
+					t.Errorf("expected %v, got %v", atom, v)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,483 @@
+package etf
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Format returns the Erlang syntax representation of t, as the Erlang
+// shell would print it.
+func Format(t Term) string {
+	var w termWriter
+	w.write(t)
+	return w.String()
+}
+
+// FormatElixir returns the Elixir syntax representation of t, as IEx
+// would print it.
+func FormatElixir(t Term) string {
+	w := termWriter{elixir: true}
+	w.write(t)
+	return w.String()
+}
+
+// formatState implements fmt.Formatter for the term types. With the
+// 'v' and 's' verbs, terms are printed using Erlang syntax, or Elixir
+// syntax if the '+' flag is given. Other verbs and %#v format plain,
+// which is t converted to a type without the Format method, the way
+// that fmt would if t didn't implement fmt.Formatter.
+func formatState(f fmt.State, verb rune, t Term, plain any) {
+	if verb == 's' || verb == 'v' && !f.Flag('#') {
+		w := termWriter{elixir: f.Flag('+')}
+		w.write(t)
+		io.WriteString(f, w.String())
+		return
+	}
+
+	s := fmt.Sprintf(fmt.FormatString(f, verb), plain)
+	if rest, ok := strings.CutPrefix(s, fmt.Sprintf("%T", plain)); ok && verb == 'v' {
+		// Go syntax for composite values starts with the type name.
+		s = fmt.Sprintf("%T", t) + rest
+	}
+	io.WriteString(f, s)
+}
+
+func (t Tuple) Format(f fmt.State, verb rune) {
+	type tuple Tuple
+	formatState(f, verb, t, tuple(t))
+}
+
+func (t List) Format(f fmt.State, verb rune) {
+	type list List
+	formatState(f, verb, t, list(t))
+}
+
+func (t Map) Format(f fmt.State, verb rune) {
+	type termMap Map
+	formatState(f, verb, t, termMap(t))
+}
+
+func (t ImproperList) Format(f fmt.State, verb rune) {
+	type improperList ImproperList
+	formatState(f, verb, t, improperList(t))
+}
+
+func (t Atom) Format(f fmt.State, verb rune) {
+	type atom Atom
+	formatState(f, verb, t, atom(t))
+}
+
+func (t Pid) Format(f fmt.State, verb rune) {
+	type pid Pid
+	formatState(f, verb, t, pid(t))
+}
+
+func (t Port) Format(f fmt.State, verb rune) {
+	type port Port
+	formatState(f, verb, t, port(t))
+}
+
+func (t Ref) Format(f fmt.State, verb rune) {
+	type ref Ref
+	formatState(f, verb, t, ref(t))
+}
+
+func (t Function) Format(f fmt.State, verb rune) {
+	type function Function
+	formatState(f, verb, t, function(t))
+}
+
+func (t Export) Format(f fmt.State, verb rune) {
+	type export Export
+	formatState(f, verb, t, export(t))
+}
+
+var erlangReserved = map[string]bool{
+	"after": true, "and": true, "andalso": true, "band": true,
+	"begin": true, "bnot": true, "bor": true, "bsl": true, "bsr": true,
+	"bxor": true, "case": true, "catch": true, "cond": true, "div": true,
+	"else": true, "end": true, "fun": true, "if": true, "let": true,
+	"maybe": true, "not": true, "of": true, "or": true, "orelse": true,
+	"receive": true, "rem": true, "try": true, "when": true, "xor": true,
+}
+
+type termWriter struct {
+	strings.Builder
+	elixir bool
+}
+
+func (w *termWriter) write(t Term) {
+	switch t := t.(type) {
+	case bool:
+		w.WriteString(strconv.FormatBool(t))
+
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uintptr, uint:
+		fmt.Fprintf(w, "%d", t)
+
+	case *big.Int:
+		w.WriteString(t.String())
+
+	case float32:
+		w.writeFloat(float64(t))
+
+	case float64:
+		w.writeFloat(t)
+
+	case Atom:
+		w.writeAtom(t)
+
+	case string:
+		// STRING_EXT is a list of bytes, each of which is a Latin-1
+		// character.
+		runes := make([]rune, len(t))
+		for i := range runes {
+			runes[i] = rune(t[i])
+		}
+		w.writeCharlist(runes)
+
+	case Charlist:
+		w.writeCharlist([]rune(string(t)))
+
+	case []byte:
+		w.writeBinary(t)
+
+	case Tuple:
+		w.WriteByte('{')
+		w.writeElements(t)
+		w.WriteByte('}')
+
+	case List:
+		w.WriteByte('[')
+		w.writeElements(t)
+		w.WriteByte(']')
+
+	case ImproperList:
+		w.WriteByte('[')
+		w.writeElements(t.Elements)
+		w.WriteString(" | ")
+		w.write(t.Tail)
+		w.WriteByte(']')
+
+	case Map:
+		w.writeMap(t)
+
+	case Pid:
+		if w.elixir {
+			w.WriteString("#PID")
+		}
+		fmt.Fprintf(w, "<0.%d.%d>", t.Id, t.Serial)
+
+	case Port:
+		fmt.Fprintf(w, "#Port<0.%d>", t.Id)
+
+	case Ref:
+		if w.elixir {
+			w.WriteString("#Reference<0")
+		} else {
+			w.WriteString("#Ref<0")
+		}
+		// The first ID word is the least significant.
+		for i := len(t.Id) - 1; i >= 0; i-- {
+			fmt.Fprintf(w, ".%d", t.Id[i])
+		}
+		w.WriteByte('>')
+
+	case Function:
+		if w.elixir {
+			fmt.Fprintf(w, "#Function<%d.%d/%d in ", t.OldIndex, t.OldUnique, t.Arity)
+			w.writeAtom(t.Module)
+			w.WriteByte('>')
+			break
+		}
+		w.WriteString("#Fun<")
+		w.writeAtom(t.Module)
+		fmt.Fprintf(w, ".%d.%d>", t.OldIndex, t.OldUnique)
+
+	case Export:
+		if w.elixir {
+			w.WriteByte('&')
+			w.writeAtom(t.Module)
+			w.WriteByte('.')
+			w.WriteString(strings.TrimPrefix(elixirAtom(t.Function), ":"))
+			fmt.Fprintf(w, "/%d", t.Arity)
+			break
+		}
+		w.WriteString("fun ")
+		w.writeAtom(t.Module)
+		w.WriteByte(':')
+		w.writeAtom(t.Function)
+		fmt.Fprintf(w, "/%d", t.Arity)
+
+	default:
+		if n, ok := normalize(t); ok {
+			w.write(n)
+			break
+		}
+		fmt.Fprintf(w, "%v", t)
+	}
+}
+
+func (w *termWriter) writeElements(elems []Term) {
+	for i, e := range elems {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		w.write(e)
+	}
+}
+
+func (w *termWriter) writeMap(m Map) {
+	keywords := w.elixir
+	for _, entry := range m {
+		if a, ok := entry.Key.(Atom); !ok || !isBareElixirAtom(string(a)) {
+			keywords = false
+		}
+	}
+
+	if w.elixir {
+		w.WriteString("%{")
+	} else {
+		w.WriteString("#{")
+	}
+	for i, entry := range m {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		if keywords {
+			w.WriteString(string(entry.Key.(Atom)))
+			w.WriteString(": ")
+		} else {
+			w.write(entry.Key)
+			w.WriteString(" => ")
+		}
+		w.write(entry.Value)
+	}
+	w.WriteByte('}')
+}
+
+// writeFloat writes a float the way that Erlang prints them, which
+// always includes a fractional part, such as 1.0 or 1.5e100.
+func (w *termWriter) writeFloat(f float64) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		w.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+		return
+	}
+
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	mant, exp, hasExp := strings.Cut(s, "e")
+	w.WriteString(mant)
+	if !strings.Contains(mant, ".") {
+		w.WriteString(".0")
+	}
+	if hasExp {
+		neg := strings.HasPrefix(exp, "-")
+		exp = strings.TrimLeft(exp, "+-0")
+		w.WriteByte('e')
+		if neg {
+			w.WriteByte('-')
+		}
+		w.WriteString(exp)
+	}
+}
+
+func (w *termWriter) writeAtom(a Atom) {
+	if w.elixir {
+		w.WriteString(elixirAtom(a))
+		return
+	}
+	w.WriteString(erlangAtom(a))
+}
+
+func (w *termWriter) writeCharlist(s []rune) {
+	if w.elixir {
+		w.WriteString("~c")
+	}
+	w.WriteByte('"')
+	for _, c := range s {
+		writeEscaped(&w.Builder, c, '"')
+	}
+	w.WriteByte('"')
+}
+
+func (w *termWriter) writeBinary(b []byte) {
+	if len(b) == 0 && w.elixir {
+		w.WriteString(`""`)
+		return
+	}
+
+	if printable(b) {
+		switch {
+		case w.elixir:
+			quote(&w.Builder, string(b), '"')
+		case isASCII(b):
+			w.WriteString("<<")
+			quote(&w.Builder, string(b), '"')
+			w.WriteString(">>")
+		default:
+			w.WriteString("<<")
+			quote(&w.Builder, string(b), '"')
+			w.WriteString("/utf8>>")
+		}
+		return
+	}
+
+	w.WriteString("<<")
+	for i, c := range b {
+		if i > 0 {
+			w.WriteByte(',')
+			if w.elixir {
+				w.WriteByte(' ')
+			}
+		}
+		w.WriteString(strconv.Itoa(int(c)))
+	}
+	w.WriteString(">>")
+}
+
+// erlangAtom returns the Erlang syntax for an atom, quoting it if
+// necessary.
+func erlangAtom(a Atom) string {
+	if isBareErlangAtom(string(a)) {
+		return string(a)
+	}
+
+	var buf strings.Builder
+	quote(&buf, string(a), '\'')
+	return buf.String()
+}
+
+func isBareErlangAtom(s string) bool {
+	if s == "" || s[0] < 'a' || s[0] > 'z' || erlangReserved[s] {
+		return false
+	}
+	for _, c := range s {
+		if !isIdentChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// elixirAtom returns the Elixir syntax for an atom. Atoms that name
+// Elixir modules are printed as aliases.
+func elixirAtom(a Atom) string {
+	switch a {
+	case "true", "false", "nil":
+		return string(a)
+	}
+
+	if alias, ok := strings.CutPrefix(string(a), "Elixir."); ok && isElixirAlias(alias) {
+		return alias
+	}
+	if isBareElixirAtom(string(a)) {
+		return ":" + string(a)
+	}
+
+	var buf strings.Builder
+	buf.WriteByte(':')
+	quote(&buf, string(a), '"')
+	return buf.String()
+}
+
+func isBareElixirAtom(s string) bool {
+	if s == "" || !(s[0] == '_' || isLetter(rune(s[0]))) {
+		return false
+	}
+	s = strings.TrimRight(s, "?!")
+	for _, c := range s {
+		if !isIdentChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isElixirAlias(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if part == "" || part[0] < 'A' || part[0] > 'Z' {
+			return false
+		}
+		for _, c := range part {
+			if !isIdentChar(c) || c == '@' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isLetter(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c rune) bool {
+	return isLetter(c) || c >= '0' && c <= '9' || c == '_' || c == '@'
+}
+
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// printable reports whether b is valid UTF-8 text that can be printed
+// as a string literal.
+func printable(b []byte) bool {
+	if len(b) == 0 || !utf8.Valid(b) {
+		return false
+	}
+	for _, c := range string(b) {
+		if !printableRune(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// printableRune reports whether c can be printed in a string literal,
+// either as itself or as a named escape sequence.
+func printableRune(c rune) bool {
+	return unicode.IsPrint(c) || escapes[c] != ""
+}
+
+var escapes = map[rune]string{
+	'\\':   `\\`,
+	'\n':   `\n`,
+	'\r':   `\r`,
+	'\t':   `\t`,
+	'\v':   `\v`,
+	'\b':   `\b`,
+	'\f':   `\f`,
+	'\x1b': `\e`,
+}
+
+func quote(buf *strings.Builder, s string, q rune) {
+	buf.WriteRune(q)
+	for _, c := range s {
+		writeEscaped(buf, c, q)
+	}
+	buf.WriteRune(q)
+}
+
+func writeEscaped(buf *strings.Builder, c, q rune) {
+	switch {
+	case c == q:
+		buf.WriteByte('\\')
+		buf.WriteRune(c)
+	case escapes[c] != "":
+		buf.WriteString(escapes[c])
+	case unicode.IsPrint(c):
+		buf.WriteRune(c)
+	default:
+		fmt.Fprintf(buf, `\x{%X}`, c)
+	}
+}
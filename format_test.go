@@ -0,0 +1,85 @@
+package etf
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	test := func(in Term, erl, ex string) {
+		if v := Format(in); v != erl {
+			t.Errorf("expected %s, got %s", erl, v)
+		}
+		if v := FormatElixir(in); v != ex {
+			t.Errorf("expected %s, got %s", ex, v)
+		}
+	}
+
+	test(1, "1", "1")
+	test(-300, "-300", "-300")
+	test(new(big.Int).Lsh(big.NewInt(1), 70), "1180591620717411303424", "1180591620717411303424")
+	test(1.0, "1.0", "1.0")
+	test(0.1, "0.1", "0.1")
+	test(1e100, "1.0e100", "1.0e100")
+	test(-2.5e-10, "-2.5e-10", "-2.5e-10")
+
+	test(true, "true", "true")
+	test(Atom("ok"), "ok", ":ok")
+	test(Atom("nil"), "nil", "nil")
+	test(Atom("Ok"), "'Ok'", ":Ok")
+	test(Atom("hello world"), "'hello world'", `:"hello world"`)
+	test(Atom("it's"), `'it\'s'`, `:"it's"`)
+	test(Atom("receive"), "'receive'", ":receive")
+	test(Atom("node@host"), "node@host", ":node@host")
+	test(Atom("valid?"), "'valid?'", ":valid?")
+	test(Atom("Elixir.Foo.Bar"), "'Elixir.Foo.Bar'", "Foo.Bar")
+	test(Atom(""), "''", `:""`)
+
+	test("abc", `"abc"`, `~c"abc"`)
+	test("a\"b\n", `"a\"b\n"`, `~c"a\"b\n"`)
+	test("\x01", `"\x{1}"`, `~c"\x{1}"`)
+
+	test([]byte("bin"), `<<"bin">>`, `"bin"`)
+	test([]byte("héllo"), `<<"héllo"/utf8>>`, `"héllo"`)
+	test([]byte{1, 2, 255}, "<<1,2,255>>", "<<1, 2, 255>>")
+	test([]byte{}, "<<>>", `""`)
+
+	test(Tuple{Atom("ok"), []byte("bin")}, `{ok, <<"bin">>}`, `{:ok, "bin"}`)
+	test(Tuple{}, "{}", "{}")
+	test(List{1, List{2}, Atom("a")}, "[1, [2], a]", "[1, [2], :a]")
+
+	test(Pid{Atom("a@h"), 12, 0, 0}, "<0.12.0>", "#PID<0.12.0>")
+	test(Port{Atom("a@h"), 7, 0}, "#Port<0.7>", "#Port<0.7>")
+	test(Ref{Atom("a@h"), 0, []uint32{3, 2, 1}}, "#Ref<0.1.2.3>", "#Reference<0.1.2.3>")
+	test(Export{Atom("lists"), Atom("map"), 2}, "fun lists:map/2", "&:lists.map/2")
+	test(Export{Atom("Elixir.Enum"), Atom("map"), 2}, "fun 'Elixir.Enum':map/2", "&Enum.map/2")
+	test(Function{Module: Atom("erl_eval"), OldIndex: 6, OldUnique: 123, Arity: 1}, "#Fun<erl_eval.6.123>", "#Function<6.123/1 in :erl_eval>")
+
+	type rec struct {
+		A Atom
+		N int
+	}
+	test(rec{Atom("ok"), 1}, "{ok, 1}", "{:ok, 1}")
+}
+
+func TestFormatter(t *testing.T) {
+	test := func(format string, in Term, exp string) {
+		if v := fmt.Sprintf(format, in); v != exp {
+			t.Errorf("expected %s, got %s", exp, v)
+		}
+	}
+
+	test("%v", Tuple{Atom("ok"), 1}, "{ok, 1}")
+	test("%+v", Tuple{Atom("ok"), 1}, "{:ok, 1}")
+	test("%s", Atom("Quoted"), "'Quoted'")
+	test("%v", List{Pid{Atom("a@h"), 1, 2, 0}}, "[<0.1.2>]")
+	test("%+v", Ref{Atom("a@h"), 0, []uint32{1}}, "#Reference<0.1>")
+
+	test("%q", Atom("ok"), `"ok"`)
+	test("%x", Atom("ok"), "6f6b")
+	test("%d", List{1, 2}, "[1 2]")
+	test("%#v", Atom("ok"), `"ok"`)
+	test("%#v", Tuple{Atom("ok")}, `etf.Tuple{"ok"}`)
+	test("%#v", Port{Atom("a@h"), 7, 0}, `etf.Port{Node:"a@h", Id:0x7, Creation:0x0}`)
+}
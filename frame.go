@@ -0,0 +1,195 @@
+package etf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ErrFrameTooBig is returned when a frame is bigger than the maximum
+// frame size of a FramedReader or FramedWriter.
+var ErrFrameTooBig = errors.New("frame: frame is too big")
+
+// DefaultMaxFrameSize is the size of the largest frame that a
+// FramedReader reads if its MaxFrameSize is 0.
+const DefaultMaxFrameSize = 64 << 20
+
+// A FramedReader reads terms that are each preceded by a big-endian
+// length header, as sent by a gen_tcp socket with the {packet, N}
+// option. Each frame must contain exactly one term, starting with the
+// version byte.
+type FramedReader struct {
+	// MaxFrameSize is the size of the largest frame that will be read,
+	// not counting its header. If it is 0, DefaultMaxFrameSize is used.
+	// If it is negative, any frame that fits in an int is read, which
+	// lets the other side make the reader allocate up to 4 GiB with a
+	// 4 byte header. The limit applies to the frame buffer; decoding
+	// the term in a frame allocates in proportion to the frame's size,
+	// whatever lengths the term claims.
+	MaxFrameSize int
+
+	r      io.Reader
+	header int
+	d      *Decoder
+	frame  bytes.Reader
+	buf    []byte
+}
+
+// NewFramedReader returns a FramedReader that reads frames with
+// headers of the given size from r, decoding them using a Decoder with
+// the given options and a new Context. It panics if headerSize isn't 1,
+// 2 or 4.
+func NewFramedReader(r io.Reader, headerSize int, opts ...DecoderOption) *FramedReader {
+	return new(Context).FramedReader(r, headerSize, opts...)
+}
+
+// FramedReader is like NewFramedReader, but the Decoder uses c.
+func (c *Context) FramedReader(r io.Reader, headerSize int, opts ...DecoderOption) *FramedReader {
+	checkHeaderSize(headerSize)
+
+	f := &FramedReader{r: r, header: headerSize}
+	f.d = c.Decoder(&f.frame, opts...)
+	return f
+}
+
+// Decoder returns the Decoder used to decode frames, which can be used
+// to set atom cache references.
+func (f *FramedReader) Decoder() *Decoder {
+	return f.d
+}
+
+// ReadTerm reads the next frame and returns the term in it. Empty
+// frames, which Erlang sends as keepalives, are skipped. It returns
+// io.EOF if r ends before the next frame and io.ErrUnexpectedEOF if it
+// ends in the middle of one.
+func (f *FramedReader) ReadTerm() (Term, error) {
+	frame, err := f.readFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	if frame[0] != EtVersion {
+		return nil, fmt.Errorf("frame: expected version byte, got %d", frame[0])
+	}
+
+	// Atom cache references set with Decoder apply to every frame
+	// until they are set again.
+	refs := f.d.atomRefs
+	f.frame.Reset(frame)
+	f.d.Reset(&f.frame)
+	f.d.atomRefs = refs
+	term, err := f.d.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if n := f.left(); n != 0 {
+		return nil, fmt.Errorf("frame: %d bytes left after term", n)
+	}
+	return term, nil
+}
+
+// readFrame reads the next non-empty frame. The returned slice is only
+// valid until the next call.
+func (f *FramedReader) readFrame() ([]byte, error) {
+	var size int
+	for size == 0 {
+		var err error
+		if size, err = f.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+	max := f.MaxFrameSize
+	if max == 0 {
+		max = DefaultMaxFrameSize
+	}
+	if max > 0 && size > max {
+		return nil, fmt.Errorf("%w (%d bytes)", ErrFrameTooBig, size)
+	}
+
+	if cap(f.buf) < size {
+		f.buf = make([]byte, size)
+	}
+	f.buf = f.buf[:size]
+	if _, err := io.ReadFull(f.r, f.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return f.buf, nil
+}
+
+// left returns the number of bytes of the current frame that haven't
+// been decoded.
+func (f *FramedReader) left() int {
+	return f.frame.Len() + f.d.r.Buffered()
+}
+
+func (f *FramedReader) readHeader() (int, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(f.r, b[:f.header]); err != nil {
+		return 0, err
+	}
+
+	var size int
+	for _, c := range b[:f.header] {
+		// A 4 byte size can overflow an int on 32-bit platforms.
+		if size > math.MaxInt>>8 {
+			return 0, fmt.Errorf("%w (%d byte header overflows int)", ErrFrameTooBig, f.header)
+		}
+		size = size<<8 | int(c)
+	}
+	return size, nil
+}
+
+// A FramedWriter writes terms that are each preceded by a big-endian
+// length header, as expected by a gen_tcp socket with the {packet, N}
+// option. Each term is written with a single call to Write.
+type FramedWriter struct {
+	// MaxFrameSize is the size of the largest frame that will be
+	// written, not counting its header. If it is 0, any frame that fits
+	// in the header is written.
+	MaxFrameSize int
+
+	e *Encoder
+}
+
+// NewFramedWriter returns a FramedWriter that writes frames with
+// headers of the given size to w, encoding terms using an Encoder with
+// the given options and a new Context. It panics if headerSize isn't 1,
+// 2 or 4.
+func NewFramedWriter(w io.Writer, headerSize int, opts ...EncoderOption) *FramedWriter {
+	return new(Context).FramedWriter(w, headerSize, opts...)
+}
+
+// FramedWriter is like NewFramedWriter, but the Encoder uses c.
+func (c *Context) FramedWriter(w io.Writer, headerSize int, opts ...EncoderOption) *FramedWriter {
+	checkHeaderSize(headerSize)
+
+	opts = append(opts, EncodePacket(headerSize))
+	return &FramedWriter{e: c.Encoder(w, opts...)}
+}
+
+// WriteTerm writes a term in a frame of its own. If the term can't be
+// encoded or is too big, nothing is written.
+func (f *FramedWriter) WriteTerm(term Term) error {
+	if err := f.e.frame(term); err != nil {
+		return err
+	}
+
+	if size := f.e.Buffered() - f.e.opts.Packet; f.MaxFrameSize > 0 && size > f.MaxFrameSize {
+		f.e.buf.Reset()
+		return fmt.Errorf("%w (%d bytes)", ErrFrameTooBig, size)
+	}
+	return f.e.Flush()
+}
+
+func checkHeaderSize(size int) {
+	switch size {
+	case 1, 2, 4:
+	default:
+		panic(fmt.Errorf("invalid frame header size %d", size))
+	}
+}
@@ -0,0 +1,128 @@
+package etf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFramedRoundTrip(t *testing.T) {
+	terms := []Term{
+		Atom("ok"),
+		Tuple{Atom("reply"), 1, List{1, 2, 3}},
+		[]byte("binary"),
+		Pid{Atom("a@h"), 1, 2, 3},
+	}
+
+	for _, size := range []int{1, 2, 4} {
+		buf := new(bytes.Buffer)
+		w := NewFramedWriter(buf, size)
+		for _, term := range terms {
+			if err := w.WriteTerm(term); err != nil {
+				t.Fatalf("packet %d: %v", size, err)
+			}
+		}
+
+		r := NewFramedReader(buf, size)
+		for _, exp := range terms {
+			if v, err := r.ReadTerm(); err != nil {
+				t.Errorf("packet %d: %v", size, err)
+			} else if !Equal(v, exp) {
+				t.Errorf("packet %d: expected %v, got %v", size, exp, v)
+			}
+		}
+		if _, err := r.ReadTerm(); err != io.EOF {
+			t.Errorf("packet %d: expected EOF, got %v", size, err)
+		}
+	}
+}
+
+func TestFramedReader(t *testing.T) {
+	test := func(in []byte, exp Term, expErr error) {
+		r := NewFramedReader(bytes.NewReader(in), 2)
+		r.MaxFrameSize = 8
+		v, err := r.ReadTerm()
+		if expErr != nil {
+			if !errors.Is(err, expErr) {
+				t.Errorf("%v: expected %v, got %v", in, expErr, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Errorf("%v: %v", in, err)
+		} else if !Equal(v, exp) {
+			t.Errorf("%v: expected %v, got %v", in, exp, v)
+		}
+	}
+
+	// {ok, 1}, as sent by term_to_binary over a {packet, 2} socket.
+	test([]byte{0, 9, 131, 104, 2, 119, 2, 111, 107, 97, 1}, nil, ErrFrameTooBig)
+	test([]byte{0, 3, 131, 97, 1}, 1, nil)
+	test([]byte{0, 0, 0, 0, 0, 3, 131, 97, 1}, 1, nil)
+	test([]byte{}, nil, io.EOF)
+	test([]byte{0}, nil, io.ErrUnexpectedEOF)
+	test([]byte{0, 3, 131, 97}, nil, io.ErrUnexpectedEOF)
+	test([]byte{0, 3, 131, 104, 2}, nil, io.ErrUnexpectedEOF)
+	// A frame within the limit can claim a huge tuple without it being
+	// allocated.
+	test([]byte{0, 6, 131, 105, 0x7f, 0xff, 0xff, 0xff}, nil, io.ErrUnexpectedEOF)
+
+	r := NewFramedReader(bytes.NewReader([]byte{0, 9, 131, 104, 2, 119, 2, 111, 107, 97, 1}), 2)
+	if v, err := r.ReadTerm(); err != nil || !Equal(v, Tuple{Atom("ok"), 1}) {
+		t.Errorf("expected {ok, 1}, got %v (%v)", v, err)
+	}
+
+	// The default limit applies to a 4 byte header claiming 4 GiB.
+	huge := NewFramedReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 131}), 4)
+	if _, err := huge.ReadTerm(); !errors.Is(err, ErrFrameTooBig) {
+		t.Errorf("expected %v, got %v", ErrFrameTooBig, err)
+	}
+
+	for _, in := range [][]byte{
+		{0, 2, 97, 1},
+		{0, 4, 131, 97, 1, 0},
+	} {
+		if _, err := NewFramedReader(bytes.NewReader(in), 2).ReadTerm(); err == nil {
+			t.Errorf("%v: err == nil", in)
+		}
+	}
+}
+
+func TestFramedContext(t *testing.T) {
+	c := new(Context)
+	c.CacheAtom(7, "shared")
+
+	// Two frames, each holding ATOM_CACHE_REF 0.
+	in := []byte{0, 3, 131, ettCacheRef, 0, 0, 3, 131, ettCacheRef, 0}
+	r := c.FramedReader(bytes.NewReader(in), 2)
+	r.Decoder().SetAtomCacheRefs([]int{7})
+	for range 2 {
+		if v, err := r.ReadTerm(); err != nil || v != Atom("shared") {
+			t.Errorf("expected shared, got %v (%v)", v, err)
+		}
+	}
+
+	if w := c.FramedWriter(io.Discard, 2); w.e.c != c {
+		t.Error("FramedWriter doesn't use the Context")
+	}
+	if d := c.DistConn(readWriter{nil, io.Discard}); d.fr.d.c != c || d.e.c != c {
+		t.Error("DistConn doesn't use the Context")
+	}
+}
+
+func TestFramedWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewFramedWriter(buf, 4)
+	w.MaxFrameSize = 4
+
+	if err := w.WriteTerm(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteTerm(Atom("toolong")); !errors.Is(err, ErrFrameTooBig) {
+		t.Errorf("expected ErrFrameTooBig, got %v", err)
+	}
+	if exp := []byte{0, 0, 0, 3, 131, 97, 1}; !bytes.Equal(buf.Bytes(), exp) {
+		t.Errorf("expected %v, got %v", exp, buf.Bytes())
+	}
+}
@@ -0,0 +1,187 @@
+package etf
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Tags of the messages that gen_server:call/2 and gen_server:cast/2
+// send.
+const (
+	GenCallTag Atom = "$gen_call"
+	GenCastTag Atom = "$gen_cast"
+)
+
+// From identifies the caller of a gen_server call and the call itself.
+// It is the From argument of handle_call/3, {Pid, Tag}.
+type From struct {
+	Pid Pid
+
+	// Tag is the reference that the reply is tagged with. Since OTP
+	// 24, callers use a process alias as the reference and the tag is
+	// the improper list [alias | Ref].
+	Tag Term
+}
+
+// NewFrom returns the From for a call made by pid using ref. If alias
+// is true, ref is a process alias and the tag is in the [alias | Ref]
+// form used by OTP 24 and later.
+func NewFrom(pid Pid, ref Ref, alias bool) From {
+	if alias {
+		return From{pid, ImproperList{List{Atom("alias")}, ref}}
+	}
+	return From{pid, ref}
+}
+
+// Ref returns the reference in the tag of f, or false if the tag isn't
+// one of the forms that gen_server uses.
+func (f From) Ref() (Ref, bool) {
+	ref, _, ok := tagRef(f.Tag)
+	return ref, ok
+}
+
+// IsAlias reports whether the tag of f is in the [alias | Ref] form,
+// in which case the reply should be sent to the alias rather than to
+// the pid.
+func (f From) IsAlias() bool {
+	_, alias, ok := tagRef(f.Tag)
+	return ok && alias
+}
+
+// ReplyTo returns where the reply to the call should be sent, the way
+// gen_server:reply/2 does: the alias if the tag is an alias and
+// otherwise the pid.
+func (f From) ReplyTo() Term {
+	if ref, alias, ok := tagRef(f.Tag); ok && alias {
+		return ref
+	}
+	return f.Pid
+}
+
+// Reply returns the message that replies to the call with reply,
+// {Tag, Reply}.
+func (f From) Reply(reply Term) Tuple {
+	return Tuple{f.Tag, reply}
+}
+
+// tagRef returns the reference in a call tag, and whether it is an
+// alias.
+func tagRef(tag Term) (ref Ref, alias, ok bool) {
+	switch tag := tag.(type) {
+	case Ref:
+		return tag, false, true
+	case ImproperList:
+		if len(tag.Elements) != 1 || !Equal(tag.Elements[0], Atom("alias")) {
+			return Ref{}, false, false
+		}
+		ref, ok := tag.Tail.(Ref)
+		return ref, ok, ok
+	}
+	return Ref{}, false, false
+}
+
+// GenCall is a gen_server call, {'$gen_call', From, Request}. Because
+// its fields match the elements after the tag, it can be used with
+// Handle to serve calls:
+//
+//	etf.Handle(&r, etf.GenCallTag, func(c etf.GenCall) error {
+//		return send(c.From.ReplyTo(), c.From.Reply(handle(c.Request)))
+//	})
+type GenCall struct {
+	From    From
+	Request Term
+}
+
+// Tuple returns the message for c.
+func (c GenCall) Tuple() Tuple {
+	return Tuple{GenCallTag, Tuple{c.From.Pid, c.From.Tag}, c.Request}
+}
+
+// GenCast is a gen_server cast, {'$gen_cast', Request}. It can be used
+// with Handle the same way as GenCall.
+type GenCast struct {
+	Request Term
+}
+
+// Tuple returns the message for c.
+func (c GenCast) Tuple() Tuple {
+	return Tuple{GenCastTag, c.Request}
+}
+
+// PendingCalls correlates the replies to gen_server calls made by a Go
+// process with the calls. It is safe for concurrent use. The zero
+// value has no pending calls.
+type PendingCalls struct {
+	m       sync.Mutex
+	pending map[string]chan Term
+}
+
+// Call registers a call of request made by self using ref, which
+// should be a new reference, and returns the message to send to the
+// server and a channel that receives the reply once it is passed to
+// Deliver. If alias is true, the call uses the OTP 24 alias tag and
+// ref should be an alias for self.
+func (p *PendingCalls) Call(self Pid, ref Ref, alias bool, request Term) (GenCall, <-chan Term) {
+	reply := make(chan Term, 1)
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.pending == nil {
+		p.pending = make(map[string]chan Term)
+	}
+	p.pending[refKey(ref)] = reply
+
+	return GenCall{NewFrom(self, ref, alias), request}, reply
+}
+
+// Deliver checks whether t is the reply to a pending call, {Tag,
+// Reply}, and, if it is, sends Reply to the channel returned by Call
+// and returns true. Replies to calls that aren't pending are ignored.
+func (p *PendingCalls) Deliver(t Term) bool {
+	tuple, ok := t.(Tuple)
+	if !ok || len(tuple) != 2 {
+		return false
+	}
+	ref, _, ok := tagRef(tuple[0])
+	if !ok {
+		return false
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	k := refKey(ref)
+	reply, ok := p.pending[k]
+	if !ok {
+		return false
+	}
+	delete(p.pending, k)
+	reply <- tuple[1]
+	return true
+}
+
+// Cancel forgets the call made using ref, such as when it times out.
+// It reports whether the call was pending.
+func (p *PendingCalls) Cancel(ref Ref) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	k := refKey(ref)
+	_, ok := p.pending[k]
+	delete(p.pending, k)
+	return ok
+}
+
+// Len returns the number of pending calls.
+func (p *PendingCalls) Len() int {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return len(p.pending)
+}
+
+// refKey returns a string that identifies ref, for use as a map key.
+func refKey(ref Ref) string {
+	return fmt.Sprint(ref.Node, ref.Creation, ref.Id)
+}
@@ -0,0 +1,94 @@
+package etf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGenCallRoundTrip(t *testing.T) {
+	self := Pid{Node: "go@h", Id: 1}
+
+	for _, alias := range []bool{false, true} {
+		ref := Ref{Node: "go@h", Id: []uint32{1, 2, 3}}
+
+		var calls PendingCalls
+		call, reply := calls.Call(self, ref, alias, Tuple{Atom("get"), Atom("key")})
+
+		buf := new(bytes.Buffer)
+		if err := new(Context).Encoder(buf).Encode(call.Tuple()); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := new(Context).Decoder(buf).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var r Router
+		Handle(&r, GenCallTag, func(c GenCall) error {
+			if got, ok := c.From.Ref(); !ok || !Equal(got, ref) {
+				t.Errorf("alias %v: unexpected ref %v", alias, got)
+			}
+			if c.From.IsAlias() != alias {
+				t.Errorf("alias %v: IsAlias returned %v", alias, !alias)
+			}
+			to := c.From.ReplyTo()
+			if alias && !Equal(to, ref) || !alias && !Equal(to, self) {
+				t.Errorf("alias %v: unexpected reply destination %v", alias, to)
+			}
+			if !calls.Deliver(c.From.Reply(Atom("value"))) {
+				t.Errorf("alias %v: reply wasn't delivered", alias)
+			}
+			return nil
+		})
+		if err := r.Dispatch(msg); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case v := <-reply:
+			if !Equal(v, Atom("value")) {
+				t.Errorf("alias %v: unexpected reply %v", alias, v)
+			}
+		default:
+			t.Errorf("alias %v: no reply", alias)
+		}
+		if calls.Len() != 0 {
+			t.Errorf("alias %v: %d calls still pending", alias, calls.Len())
+		}
+	}
+}
+
+func TestGenCallTag(t *testing.T) {
+	ref := Ref{Node: "a@h", Id: []uint32{1}}
+	from := NewFrom(Pid{Node: "a@h"}, ref, true)
+	if _, ok := Match(ImproperList{List{Atom("alias")}, ref}, from.Tag); !ok {
+		t.Errorf("unexpected tag %v", from.Tag)
+	}
+
+	if _, ok := (From{Tag: Atom("x")}).Ref(); ok {
+		t.Error("found a reference in a bad tag")
+	}
+	if c := (GenCast{Atom("ping")}).Tuple(); !Equal(c, Tuple{Atom("$gen_cast"), Atom("ping")}) {
+		t.Errorf("unexpected cast %v", c)
+	}
+}
+
+func TestPendingCalls(t *testing.T) {
+	var calls PendingCalls
+	ref := Ref{Node: "a@h", Id: []uint32{7}}
+	other := Ref{Node: "a@h", Id: []uint32{8}}
+	calls.Call(Pid{}, ref, false, Atom("req"))
+
+	if calls.Deliver(Tuple{other, 1}) {
+		t.Error("delivered a reply to an unknown call")
+	}
+	if calls.Deliver(Tuple{ref, 1, 2}) {
+		t.Error("delivered a malformed reply")
+	}
+	if !calls.Cancel(ref) || calls.Cancel(ref) {
+		t.Error("Cancel didn't remove the call exactly once")
+	}
+	if calls.Deliver(Tuple{ref, 1}) {
+		t.Error("delivered a reply to a canceled call")
+	}
+}
@@ -0,0 +1,704 @@
+package etf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSafeInt is the largest integer that JSON consumers using doubles
+// can represent exactly.
+const maxSafeInt = 1<<53 - 1
+
+// JSONOptions configures the mapping used by ToJSON and FromJSON.
+type JSONOptions struct {
+	// Friendly selects a lossy mapping that produces the JSON that a
+	// client would usually expect, instead of one that can be converted
+	// back into the original term.
+	Friendly bool
+}
+
+// ToJSON converts a term to JSON.
+//
+// By default, the mapping is reversible, so that FromJSON returns the
+// original term:
+//
+//	integer                  number, or {"$int": "123"} if it is too large
+//	                         to be represented exactly by a double
+//	float                    number, always with a fraction or exponent
+//	true, false              true, false
+//	other atoms              {"$atom": "name"}
+//	UTF-8 binary             string
+//	other binaries           {"$binary": "base64 data"}
+//	string (STRING_EXT),     {"$string": "text"}, which converts back to a
+//	Charlist                 Go string if every character is in Latin-1
+//	                         and to a Charlist otherwise
+//	list                     array
+//	improper list            {"$improper": {"elements": [...], "tail": term}}
+//	tuple                    {"$tuple": [...]}
+//	map with binary keys     object
+//	other maps               {"$map": [[key, value], ...]}
+//	pid                      {"$pid": {"node", "id", "serial", "creation"}}
+//	port                     {"$port": {"node", "id", "creation"}}
+//	reference                {"$ref": {"node", "creation", "id": [...]}}
+//	external fun             {"$export": {"module", "function", "arity"}}
+//
+// With the Friendly option, atoms, binaries and strings all become
+// JSON strings, except for the atom nil, which becomes null. Tuples
+// and improper lists become arrays, maps whose keys are all atoms,
+// binaries, strings or numbers become objects, and other maps become
+// arrays of pairs. Integers that are too large for a double become
+// strings, and pids, ports, references and funs become strings in
+// Erlang syntax. It is an error for two keys of a map that becomes an
+// object to become the same object key, such as a and <<"a">>.
+func ToJSON(t Term, opts JSONOptions) ([]byte, error) {
+	w := jsonWriter{friendly: opts.Friendly}
+	if err := w.write(t); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// FromJSON converts JSON to a term. By default, it reverses the mapping
+// used by ToJSON. Objects that don't consist of a single tag become
+// maps with binary keys and null becomes the atom nil.
+//
+// With the Friendly option, tags aren't interpreted, so every object
+// becomes a map and every string becomes a binary.
+func FromJSON(data []byte, opts JSONOptions) (Term, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	v, err := readJSON(d)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		if err == nil {
+			return nil, fmt.Errorf("json: unexpected data after value")
+		}
+		return nil, fmt.Errorf("json: after value: %w", err)
+	}
+
+	r := jsonReader{friendly: opts.Friendly}
+	return r.term(v)
+}
+
+type jsonWriter struct {
+	bytes.Buffer
+	friendly bool
+}
+
+func (w *jsonWriter) write(t Term) error {
+	switch t := t.(type) {
+	case bool:
+		w.WriteString(strconv.FormatBool(t))
+
+	case int8, int16, int32, int64, int:
+		return w.writeInt(big.NewInt(reflect.ValueOf(t).Int()))
+
+	case uint8, uint16, uint32, uint64, uintptr, uint:
+		return w.writeInt(new(big.Int).SetUint64(reflect.ValueOf(t).Uint()))
+
+	case *big.Int:
+		return w.writeInt(t)
+
+	case float32:
+		return w.writeFloat(float64(t))
+
+	case float64:
+		return w.writeFloat(t)
+
+	case Atom:
+		if w.friendly {
+			if t == "nil" {
+				w.WriteString("null")
+				return nil
+			}
+			return w.writeString(string(t))
+		}
+		return w.writeTagged("$atom", func() error { return w.writeString(string(t)) })
+
+	case string:
+		// STRING_EXT is a list of Latin-1 characters.
+		runes := make([]rune, len(t))
+		for i := range runes {
+			runes[i] = rune(t[i])
+		}
+		if w.friendly {
+			return w.writeString(string(runes))
+		}
+		return w.writeTagged("$string", func() error { return w.writeString(string(runes)) })
+
+	case Charlist:
+		if w.friendly {
+			return w.writeString(string(t))
+		}
+		return w.writeTagged("$string", func() error { return w.writeString(string(t)) })
+
+	case []byte:
+		switch {
+		case utf8.Valid(t):
+			return w.writeString(string(t))
+		case w.friendly:
+			return w.writeString(base64.StdEncoding.EncodeToString(t))
+		}
+		return w.writeTagged("$binary", func() error {
+			return w.writeString(base64.StdEncoding.EncodeToString(t))
+		})
+
+	case List:
+		return w.writeArray(t)
+
+	case ImproperList:
+		if w.friendly {
+			return w.writeArray(append(t.Elements[:len(t.Elements):len(t.Elements)], t.Tail))
+		}
+		return w.writeTagged("$improper", func() error {
+			w.WriteString(`{"elements":`)
+			if err := w.writeArray(t.Elements); err != nil {
+				return err
+			}
+			w.WriteString(`,"tail":`)
+			if err := w.write(t.Tail); err != nil {
+				return err
+			}
+			w.WriteByte('}')
+			return nil
+		})
+
+	case Tuple:
+		if w.friendly {
+			return w.writeArray(t)
+		}
+		return w.writeTagged("$tuple", func() error { return w.writeArray(t) })
+
+	case Map:
+		return w.writeMap(t)
+
+	case Pid:
+		if w.friendly {
+			return w.writeString(Format(t))
+		}
+		return w.writeTagged("$pid", func() error {
+			return w.writeObject("node", string(t.Node), "id", t.Id, "serial", t.Serial, "creation", t.Creation)
+		})
+
+	case Port:
+		if w.friendly {
+			return w.writeString(Format(t))
+		}
+		return w.writeTagged("$port", func() error {
+			return w.writeObject("node", string(t.Node), "id", t.Id, "creation", t.Creation)
+		})
+
+	case Ref:
+		if w.friendly {
+			return w.writeString(Format(t))
+		}
+		return w.writeTagged("$ref", func() error {
+			return w.writeObject("node", string(t.Node), "creation", t.Creation, "id", t.Id)
+		})
+
+	case Export:
+		if w.friendly {
+			return w.writeString(Format(t))
+		}
+		return w.writeTagged("$export", func() error {
+			return w.writeObject("module", string(t.Module), "function", string(t.Function), "arity", t.Arity)
+		})
+
+	case Function:
+		if w.friendly {
+			return w.writeString(Format(t))
+		}
+		return fmt.Errorf("json: can't convert local fun %v", t)
+
+	default:
+		if n, ok := normalize(t); ok {
+			return w.write(n)
+		}
+		return fmt.Errorf("json: can't convert type %T", t)
+	}
+
+	return nil
+}
+
+// writeInt writes an integer as a JSON number if it can be represented
+// exactly by a double and as a string otherwise.
+func (w *jsonWriter) writeInt(x *big.Int) error {
+	if x.IsInt64() && x.Int64() >= -maxSafeInt && x.Int64() <= maxSafeInt {
+		w.WriteString(x.String())
+		return nil
+	}
+	if w.friendly {
+		return w.writeString(x.String())
+	}
+	return w.writeTagged("$int", func() error { return w.writeString(x.String()) })
+}
+
+// writeFloat writes a float so that it always has a fraction or an
+// exponent, distinguishing it from an integer.
+func (w *jsonWriter) writeFloat(f float64) error {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("json: can't convert %v", f)
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	w.WriteString(s)
+	if !strings.ContainsAny(s, ".e") {
+		w.WriteString(".0")
+	}
+	return nil
+}
+
+func (w *jsonWriter) writeString(s string) error {
+	enc := json.NewEncoder(&w.Buffer)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	// Encode adds a newline.
+	w.Truncate(w.Len() - 1)
+	return nil
+}
+
+func (w *jsonWriter) writeTagged(tag string, value func() error) error {
+	w.WriteByte('{')
+	if err := w.writeString(tag); err != nil {
+		return err
+	}
+	w.WriteByte(':')
+	if err := value(); err != nil {
+		return err
+	}
+	w.WriteByte('}')
+	return nil
+}
+
+func (w *jsonWriter) writeArray(elems []Term) error {
+	w.WriteByte('[')
+	for i, e := range elems {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if err := w.write(e); err != nil {
+			return err
+		}
+	}
+	w.WriteByte(']')
+	return nil
+}
+
+// writeObject writes an object with the given keys and values, which
+// are encoded using encoding/json.
+func (w *jsonWriter) writeObject(kv ...any) error {
+	w.WriteByte('{')
+	for i := 0; i < len(kv); i += 2 {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if err := w.writeString(kv[i].(string)); err != nil {
+			return err
+		}
+		w.WriteByte(':')
+		b, err := json.Marshal(kv[i+1])
+		if err != nil {
+			return err
+		}
+		w.Write(b)
+	}
+	w.WriteByte('}')
+	return nil
+}
+
+// writeMap writes a map as an object if all of its keys can be
+// converted to object keys, and as a list of pairs otherwise. In the
+// friendly mapping, different keys can convert to the same object key,
+// such as the atom a and the binary <<"a">>, which is an error if the
+// map is written as an object.
+func (w *jsonWriter) writeMap(m Map) error {
+	keys := make([]string, len(m))
+	plain := true
+	for i, entry := range m {
+		var ok bool
+		if keys[i], ok = w.mapKey(entry.Key); !ok {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		seen := make(map[string]int, len(m))
+		for i, key := range keys {
+			if j, ok := seen[key]; ok {
+				return fmt.Errorf("json: map keys %v and %v both convert to %q", Format(m[j].Key), Format(m[i].Key), key)
+			}
+			seen[key] = i
+		}
+	}
+
+	if !plain {
+		pairs := func() error {
+			w.WriteByte('[')
+			for i, entry := range m {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				if err := w.writeArray([]Term{entry.Key, entry.Value}); err != nil {
+					return err
+				}
+			}
+			w.WriteByte(']')
+			return nil
+		}
+		if w.friendly {
+			return pairs()
+		}
+		return w.writeTagged("$map", pairs)
+	}
+
+	w.WriteByte('{')
+	for i, entry := range m {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if err := w.writeString(keys[i]); err != nil {
+			return err
+		}
+		w.WriteByte(':')
+		if err := w.write(entry.Value); err != nil {
+			return err
+		}
+	}
+	w.WriteByte('}')
+	return nil
+}
+
+// mapKey returns the object key for a map key. In the reversible
+// mapping, only UTF-8 binaries that can't be mistaken for a tag are
+// allowed.
+func (w *jsonWriter) mapKey(k Term) (string, bool) {
+	if b, ok := k.([]byte); ok && utf8.Valid(b) && (w.friendly || !bytes.HasPrefix(b, []byte("$"))) {
+		return string(b), true
+	}
+	if !w.friendly {
+		return "", false
+	}
+
+	switch k := k.(type) {
+	case Atom:
+		return string(k), true
+	case bool:
+		return strconv.FormatBool(k), true
+	case string:
+		// STRING_EXT is a list of Latin-1 characters.
+		return string(latin1ToUTF8([]byte(k))), true
+	case Charlist:
+		return string(k), true
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uintptr, uint, *big.Int:
+		return fmt.Sprint(k), true
+	}
+	return "", false
+}
+
+// jsonObject is a JSON object with its fields in their original order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	key   string
+	value any
+}
+
+// readJSON reads a JSON value, preserving the order of object fields.
+// Numbers are returned as json.Number.
+func readJSON(d *json.Decoder) (any, error) {
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('['):
+		arr := []any{}
+		for d.More() {
+			v, err := readJSON(d)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := d.Token()
+		return arr, err
+
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for d.More() {
+			k, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := readJSON(d)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonField{k.(string), v})
+		}
+		_, err := d.Token()
+		return obj, err
+	}
+
+	return tok, nil
+}
+
+type jsonReader struct {
+	friendly bool
+}
+
+func (r *jsonReader) term(v any) (Term, error) {
+	switch v := v.(type) {
+	case nil:
+		return Atom("nil"), nil
+
+	case bool:
+		return v, nil
+
+	case json.Number:
+		return jsonNumber(string(v))
+
+	case string:
+		return []byte(v), nil
+
+	case []any:
+		return r.list(v)
+
+	case jsonObject:
+		if !r.friendly && len(v) == 1 && strings.HasPrefix(v[0].key, "$") {
+			return r.tagged(v[0].key, v[0].value)
+		}
+
+		m := make(Map, 0, len(v))
+		for _, field := range v {
+			value, err := r.term(field.value)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, MapEntry{[]byte(field.key), value})
+		}
+		return m, nil
+	}
+
+	panic("unreachable")
+}
+
+func jsonNumber(s string) (Term, error) {
+	if !strings.ContainsAny(s, ".eE") {
+		x, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return nil, fmt.Errorf("json: invalid integer %q", s)
+		}
+		return bigIntTerm(x), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func (r *jsonReader) list(arr []any) (List, error) {
+	list := make(List, len(arr))
+	for i, v := range arr {
+		t, err := r.term(v)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = t
+	}
+	return list, nil
+}
+
+func (r *jsonReader) tagged(tag string, v any) (Term, error) {
+	switch tag {
+	case "$atom":
+		s, err := jsonString(tag, v)
+		return Atom(s), err
+
+	case "$string":
+		s, err := jsonString(tag, v)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, 0, len(s))
+		for _, c := range s {
+			if c > math.MaxUint8 {
+				return Charlist(s), nil
+			}
+			b = append(b, byte(c))
+		}
+		return string(b), nil
+
+	case "$binary":
+		s, err := jsonString(tag, v)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(s)
+
+	case "$int":
+		s, err := jsonString(tag, v)
+		if err != nil {
+			return nil, err
+		}
+		x, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return nil, fmt.Errorf("json: %s: invalid integer %q", tag, s)
+		}
+		return bigIntTerm(x), nil
+
+	case "$tuple":
+		arr, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("json: %s: expected array", tag)
+		}
+		list, err := r.list(arr)
+		return Tuple(list), err
+
+	case "$improper":
+		obj, ok := v.(jsonObject)
+		if !ok {
+			return nil, fmt.Errorf("json: %s: expected object", tag)
+		}
+		var list ImproperList
+		for _, field := range obj {
+			var err error
+			switch field.key {
+			case "elements":
+				arr, ok := field.value.([]any)
+				if !ok {
+					return nil, fmt.Errorf("json: %s: expected elements array", tag)
+				}
+				list.Elements, err = r.list(arr)
+			case "tail":
+				list.Tail, err = r.term(field.value)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if list.Tail == nil {
+			return nil, fmt.Errorf("json: %s: missing tail", tag)
+		}
+		return list, nil
+
+	case "$map":
+		arr, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("json: %s: expected array", tag)
+		}
+		m := make(Map, len(arr))
+		for i, pair := range arr {
+			pair, ok := pair.([]any)
+			if !ok || len(pair) != 2 {
+				return nil, fmt.Errorf("json: %s: expected [key, value] pair", tag)
+			}
+			kv, err := r.list(pair)
+			if err != nil {
+				return nil, err
+			}
+			m[i] = MapEntry{kv[0], kv[1]}
+		}
+		return m, nil
+
+	case "$pid":
+		var pid struct {
+			Node     string
+			Id       uint32
+			Serial   uint32
+			Creation uint32
+		}
+		err := jsonFields(tag, v, &pid)
+		return Pid{Atom(pid.Node), pid.Id, pid.Serial, pid.Creation}, err
+
+	case "$port":
+		var port struct {
+			Node     string
+			Id       uint64
+			Creation uint32
+		}
+		err := jsonFields(tag, v, &port)
+		return Port{Atom(port.Node), port.Id, port.Creation}, err
+
+	case "$ref":
+		var ref struct {
+			Node     string
+			Creation uint32
+			Id       []uint32
+		}
+		err := jsonFields(tag, v, &ref)
+		return Ref{Atom(ref.Node), ref.Creation, ref.Id}, err
+
+	case "$export":
+		var export struct {
+			Module   string
+			Function string
+			Arity    byte
+		}
+		err := jsonFields(tag, v, &export)
+		return Export{Atom(export.Module), Atom(export.Function), export.Arity}, err
+	}
+
+	return nil, fmt.Errorf("json: unknown tag %q", tag)
+}
+
+func jsonString(tag string, v any) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("json: %s: expected string", tag)
+	}
+	return s, nil
+}
+
+// jsonFields decodes the fields of a tagged object into dst by
+// re-encoding them and using encoding/json.
+func jsonFields(tag string, v any, dst any) error {
+	obj, ok := v.(jsonObject)
+	if !ok {
+		return fmt.Errorf("json: %s: expected object", tag)
+	}
+
+	m := make(map[string]any, len(obj))
+	for _, field := range obj {
+		m[field.key] = plainJSON(field.value)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, dst); err != nil {
+		return fmt.Errorf("json: %s: %w", tag, err)
+	}
+	return nil
+}
+
+// plainJSON converts a value read by readJSON back into something that
+// encoding/json can marshal.
+func plainJSON(v any) any {
+	switch v := v.(type) {
+	case jsonObject:
+		m := make(map[string]any, len(v))
+		for _, field := range v {
+			m[field.key] = plainJSON(field.value)
+		}
+		return m
+	case []any:
+		arr := make([]any, len(v))
+		for i := range v {
+			arr[i] = plainJSON(v[i])
+		}
+		return arr
+	}
+	return v
+}
@@ -0,0 +1,107 @@
+package etf
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+	test := func(in Term, friendly bool, exp string) {
+		v, err := ToJSON(in, JSONOptions{Friendly: friendly})
+		if err != nil {
+			t.Errorf("%v: %v", in, err)
+		} else if string(v) != exp {
+			t.Errorf("%v: expected %s, got %s", in, exp, v)
+		}
+	}
+
+	big1 := new(big.Int).Lsh(big.NewInt(1), 64)
+
+	test(1, false, "1")
+	test(1.0, false, "1.0")
+	test(int64(1)<<60, false, `{"$int":"1152921504606846976"}`)
+	test(big1, false, `{"$int":"18446744073709551616"}`)
+	test(true, false, "true")
+	test(Atom("ok"), false, `{"$atom":"ok"}`)
+	test([]byte("bin"), false, `"bin"`)
+	test([]byte{0xff}, false, `{"$binary":"/w=="}`)
+	test("abc", false, `{"$string":"abc"}`)
+	test(List{1, Tuple{Atom("a")}}, false, `[1,{"$tuple":[{"$atom":"a"}]}]`)
+	test(ImproperList{List{1}, 2}, false, `{"$improper":{"elements":[1],"tail":2}}`)
+	test(Map{{[]byte("k"), 1}}, false, `{"k":1}`)
+	test(Map{{Atom("k"), 1}}, false, `{"$map":[[{"$atom":"k"},1]]}`)
+	test(Map{{[]byte("$k"), 1}}, false, `{"$map":[["$k",1]]}`)
+	test(Pid{Atom("a@h"), 1, 2, 3}, false, `{"$pid":{"node":"a@h","id":1,"serial":2,"creation":3}}`)
+	test(Ref{Atom("a@h"), 1, []uint32{1, 2}}, false, `{"$ref":{"node":"a@h","creation":1,"id":[1,2]}}`)
+
+	test(Atom("ok"), true, `"ok"`)
+	test(Atom("nil"), true, "null")
+	test("abc", true, `"abc"`)
+	test(big1, true, `"18446744073709551616"`)
+	test(Tuple{Atom("ok"), []byte("bin")}, true, `["ok","bin"]`)
+	test(ImproperList{List{1}, 2}, true, `[1,2]`)
+	test(Map{{Atom("a"), 1}, {[]byte("b"), 2}, {3, 4}}, true, `{"a":1,"b":2,"3":4}`)
+	test(Map{{Tuple{}, 1}}, true, `[[[],1]]`)
+	test(Pid{Atom("a@h"), 1, 2, 3}, true, `"<0.1.2>"`)
+	test(Map{{"\xe9", 1}, {Charlist("€"), 2}}, true, `{"é":1,"€":2}`)
+	// Keys that collide don't matter if another key needs pairs.
+	test(Map{{Atom("a"), 1}, {[]byte("a"), 2}, {Tuple{}, 3}}, true, `[["a",1],["a",2],[[],3]]`)
+
+	for _, in := range []Term{
+		Map{{Atom("a"), 1}, {[]byte("a"), 2}},
+		Map{{1, 1}, {"1", 2}},
+	} {
+		if _, err := ToJSON(in, JSONOptions{Friendly: true}); err == nil {
+			t.Errorf("%v: err == nil", in)
+		}
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	test := func(in string, friendly bool, exp Term) {
+		v, err := FromJSON([]byte(in), JSONOptions{Friendly: friendly})
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+		} else if !Equal(v, exp) {
+			t.Errorf("%s: expected %v, got %v", in, exp, v)
+		}
+	}
+
+	test("1", false, 1)
+	test("1.0", false, 1.0)
+	test("null", false, Atom("nil"))
+	test(`"bin"`, false, []byte("bin"))
+	test(`{"a": [1, true]}`, false, Map{{[]byte("a"), List{1, true}}})
+	test(`{"$atom": "ok"}`, false, Atom("ok"))
+	test(`{"$atom": "ok"}`, true, Map{{[]byte("$atom"), []byte("ok")}})
+
+	for _, in := range []string{"", "[1", `{"$tuple": 1}`, `{"$nope": 1}`, "1 2", `{"a":1} xyz`, "[] ]"} {
+		if _, err := FromJSON([]byte(in), JSONOptions{}); err == nil {
+			t.Errorf("%q: err == nil", in)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	test := func(in Term) {
+		j, err := ToJSON(in, JSONOptions{})
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		v, err := FromJSON(j, JSONOptions{})
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		// Compare alone would consider 1 and 1.0 equal.
+		if Format(v) != Format(in) {
+			t.Errorf("expected %v, got %v (via %s)", in, v, j)
+		}
+	}
+
+	test(Tuple{Atom("ok"), 1.0, -3, "str", []byte{1, 255}})
+	test(ImproperList{List{1, 2}, Atom("t")})
+	test(Map{{[]byte("k"), List{}}, {Tuple{Atom("a")}, Map{}}})
+	test(new(big.Int).Lsh(big.NewInt(1), 100))
+	test(List{Pid{Atom("a@h"), 1, 2, 3}, Port{Atom("a@h"), 4, 5}, Ref{Atom("a@h"), 6, []uint32{7, 8, 9}}})
+	test(Export{Atom("lists"), Atom("map"), 2})
+}
@@ -0,0 +1,643 @@
+package etf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrNoProcess is returned, wrapped, when a message is sent to a
+// registered name that no process has.
+var ErrNoProcess = errors.New("node: no such process")
+
+// ExitError is returned by the receive methods of a process that has
+// exited, and can be returned by the function run by Spawn to exit
+// with a specific reason.
+type ExitError struct {
+	Reason Term
+}
+
+func (err *ExitError) Error() string {
+	return fmt.Sprintf("process exited: %s", Format(err.Reason))
+}
+
+// Node is a set of local processes that can send each other messages,
+// like an Erlang node. Processes are identified by Pid values with the
+// node's name and creation, allocated the same way that Erlang
+// allocates them, so that they can be sent to other nodes.
+type Node struct {
+	name     Atom
+	creation uint32
+
+	m      sync.Mutex
+	procs  map[Pid]*Process
+	names  map[Atom]*Process
+	conns  map[Atom]*DistConn
+	nextID uint32
+	serial uint32
+	refs   uint64
+
+	glOnce sync.Once
+	gl     Pid
+}
+
+// NewNode returns a Node with the given name, such as "go@localhost",
+// and creation. The creation should be the one assigned to the node by
+// EPMD, or any value if the node doesn't connect to other nodes.
+func NewNode(name Atom, creation uint32) *Node {
+	return &Node{
+		name:     name,
+		creation: creation,
+		procs:    make(map[Pid]*Process),
+		names:    make(map[Atom]*Process),
+		conns:    make(map[Atom]*DistConn),
+	}
+}
+
+// Name returns the name of the node.
+func (n *Node) Name() Atom {
+	return n.name
+}
+
+// newPid allocates a pid. Like Erlang, it uses a 15-bit ID and counts
+// up the 13-bit serial each time the ID wraps, so that the pid fits in
+// PID_EXT, and skips pids that are in use. n.m must be held.
+func (n *Node) newPid() Pid {
+	for {
+		n.nextID++
+		if n.nextID >= 1<<15 {
+			n.nextID = 0
+			n.serial = (n.serial + 1) % (1 << 13)
+		}
+		pid := Pid{Node: n.name, Id: n.nextID, Serial: n.serial, Creation: n.creation}
+		if _, ok := n.procs[pid]; !ok {
+			return pid
+		}
+	}
+}
+
+// MakeRef returns a new reference that is unique on the node, like
+// make_ref/0. References have three ID words, the first of which only
+// uses 18 bits, as Erlang's do.
+func (n *Node) MakeRef() Ref {
+	n.m.Lock()
+	n.refs++
+	c := n.refs
+	n.m.Unlock()
+
+	return Ref{
+		Node:     n.name,
+		Creation: n.creation,
+		Id:       []uint32{uint32(c % (1 << 18)), uint32(c >> 18), uint32(c >> 50)},
+	}
+}
+
+// NewProcess creates a process that isn't run by a goroutine of its
+// own, which is useful for handling messages on behalf of something
+// else, such as a connection. It exits when its Exit method is called.
+func (n *Node) NewProcess() *Process {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	p := &Process{
+		node:     n,
+		pid:      n.newPid(),
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		links:    make(map[Pid]struct{}),
+		monitors: make(map[string]monitor),
+		watching: make(map[string]Pid),
+	}
+	n.procs[p.pid] = p
+	return p
+}
+
+// Spawn creates a process and runs f in a new goroutine. The process
+// exits when f returns, with the reason normal if f returns nil, the
+// reason in an *ExitError, or the message of any other error as a
+// binary.
+func (n *Node) Spawn(f func(p *Process) error) Pid {
+	p := n.NewProcess()
+	go func() {
+		var reason Term = Atom("normal")
+		var exit *ExitError
+		switch err := f(p); {
+		case errors.As(err, &exit):
+			reason = exit.Reason
+		case err != nil:
+			reason = []byte(err.Error())
+		}
+		p.Exit(reason)
+	}()
+	return p.pid
+}
+
+// Register associates name with the process pid, like register/2. It
+// returns an error if the name is taken or if pid isn't a live process
+// or already has a name.
+func (n *Node) Register(name Atom, pid Pid) error {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	p, ok := n.procs[pid]
+	if !ok {
+		return fmt.Errorf("node: register %v: %w", name, ErrNoProcess)
+	}
+	if _, ok := n.names[name]; ok {
+		return fmt.Errorf("node: name %v is already registered", name)
+	}
+	if p.name != "" {
+		return fmt.Errorf("node: %v is already registered as %v", pid, p.name)
+	}
+	n.names[name] = p
+	p.name = name
+	return nil
+}
+
+// Unregister removes a registered name. It reports whether the name
+// was registered.
+func (n *Node) Unregister(name Atom) bool {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	p, ok := n.names[name]
+	if ok {
+		delete(n.names, name)
+		p.name = ""
+	}
+	return ok
+}
+
+// WhereIs returns the pid registered as name, like whereis/1.
+func (n *Node) WhereIs(name Atom) (Pid, bool) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	p, ok := n.names[name]
+	if !ok {
+		return Pid{}, false
+	}
+	return p.pid, true
+}
+
+// lookup returns the live local process with the given pid.
+func (n *Node) lookup(pid Pid) (*Process, bool) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	p, ok := n.procs[pid]
+	return p, ok
+}
+
+// Send sends msg to the process to, which is either a Pid or a
+// registered name. As in Erlang, messages sent to pids that don't
+// exist are silently dropped, but sending to a name that isn't
+// registered is an error. Messages to pids of other nodes are sent
+// over the connection being served by ServeConn, if there is one.
+//
+// Sending to a name registered on another node, {Name, Node}, needs a
+// sender, so it has to be done with Process.Send.
+func (n *Node) Send(to Term, msg Term) error {
+	return n.send(Pid{}, to, msg)
+}
+
+func (n *Node) send(from Pid, to Term, msg Term) error {
+	var p *Process
+	switch to := to.(type) {
+	case Pid:
+		if to.Node != n.name {
+			conn, err := n.conn(to.Node)
+			if err != nil {
+				return err
+			}
+			return conn.WriteMessage(Tuple{DistSend, Atom(""), to}, msg)
+		}
+		p, _ = n.lookup(to)
+
+	case Atom:
+		n.m.Lock()
+		p = n.names[to]
+		n.m.Unlock()
+		if p == nil {
+			return fmt.Errorf("node: send to %v: %w", to, ErrNoProcess)
+		}
+
+	case Tuple:
+		var name, node Atom
+		if err := MatchTuple(to, &name, &node); err != nil {
+			return fmt.Errorf("node: can't send to %s", Format(to))
+		}
+		if node == n.name {
+			return n.send(from, name, msg)
+		}
+		if from == (Pid{}) {
+			return fmt.Errorf("node: send to %s: no sender", Format(to))
+		}
+		conn, err := n.conn(node)
+		if err != nil {
+			return err
+		}
+		return conn.WriteMessage(Tuple{DistRegSend, from, Atom(""), name}, msg)
+
+	default:
+		return fmt.Errorf("node: can't send to %s", Format(to))
+	}
+
+	if p != nil {
+		p.deliver(msg)
+	}
+	return nil
+}
+
+// conn returns the connection to the node called name.
+func (n *Node) conn(name Atom) (*DistConn, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	conn, ok := n.conns[name]
+	if !ok {
+		return nil, fmt.Errorf("node: not connected to %v", name)
+	}
+	return conn, nil
+}
+
+// ServeConn uses conn as the connection to the node called peer until
+// reading from it fails, delivering the messages that it receives to
+// local processes. It returns nil if the connection is closed cleanly,
+// and otherwise the error that ended it.
+//
+// The SEND, REG_SEND and SEND_SENDER control messages are handled, as
+// are SPAWN_REPLY and MONITOR_P_EXIT, which are delivered as
+// {spawn_reply, ReqId, ok | error, Pid | Reason} and DOWN messages to
+// the processes that made the spawn requests and monitors. Links and
+// exit signals between nodes aren't supported, and neither is spawning
+// processes for other nodes, so the other control messages are
+// ignored.
+func (n *Node) ServeConn(peer Atom, conn *DistConn) error {
+	n.m.Lock()
+	if _, ok := n.conns[peer]; ok {
+		n.m.Unlock()
+		return fmt.Errorf("node: already connected to %v", peer)
+	}
+	n.conns[peer] = conn
+	n.m.Unlock()
+
+	defer func() {
+		n.m.Lock()
+		delete(n.conns, peer)
+		n.m.Unlock()
+	}()
+
+	for {
+		control, msg, err := conn.ReadMessage()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		op, _ := As[int](control[0])
+		switch {
+		case op == DistSend && len(control) == 3, op == DistSendSender && len(control) == 3:
+			if to, ok := control[2].(Pid); ok && to.Node == n.name {
+				n.send(Pid{}, to, msg)
+			}
+		case op == DistRegSend && len(control) == 4:
+			if to, ok := control[3].(Atom); ok {
+				n.send(Pid{}, to, msg)
+			}
+		case op == DistSpawnReply && len(control) == 5:
+			if to, ok := control[2].(Pid); ok && to.Node == n.name {
+				reply := Tuple{Atom("spawn_reply"), control[1], Atom("ok"), control[4]}
+				if _, ok := control[4].(Pid); !ok {
+					reply[2] = Atom("error")
+				}
+				n.send(Pid{}, to, reply)
+			}
+		case op == DistMonitorPExit && len(control) == 5:
+			if to, ok := control[2].(Pid); ok && to.Node == n.name {
+				n.send(Pid{}, to, Tuple{Atom("DOWN"), control[3], Atom("process"), control[1], control[4]})
+			}
+		}
+	}
+}
+
+// remove removes an exited process from the registry.
+func (n *Node) remove(p *Process) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	delete(n.procs, p.pid)
+	if p.name != "" {
+		delete(n.names, p.name)
+		p.name = ""
+	}
+}
+
+// Process is a local process of a Node with a mailbox that receives
+// messages sent to its pid. Only the goroutine that runs the process
+// should receive from its mailbox, but other methods are safe for
+// concurrent use.
+type Process struct {
+	node *Node
+	pid  Pid
+	name Atom // guarded by node.m
+
+	m        sync.Mutex
+	queue    []Term
+	notify   chan struct{}
+	done     chan struct{}
+	reason   Term
+	trapExit bool
+	links    map[Pid]struct{}
+	monitors map[string]monitor // monitors of p by other processes
+	watching map[string]Pid     // monitors of other processes by p
+}
+
+// monitor is a monitor of a process by another process.
+type monitor struct {
+	ref     Ref
+	watcher Pid
+}
+
+// Pid returns the pid of p.
+func (p *Process) Pid() Pid {
+	return p.pid
+}
+
+// Node returns the node that p belongs to.
+func (p *Process) Node() *Node {
+	return p.node
+}
+
+// Send sends msg from p to another process. It is the same as
+// p.Node().Send, except that it can also send to a name registered on
+// another node, {Name, Node}.
+func (p *Process) Send(to Term, msg Term) error {
+	return p.node.send(p.pid, to, msg)
+}
+
+// deliver adds msg to the mailbox. Messages to exited processes are
+// dropped.
+func (p *Process) deliver(msg Term) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.reason != nil {
+		return
+	}
+	p.queue = append(p.queue, msg)
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Receive removes the oldest message from the mailbox and returns it,
+// waiting for one to arrive if it is empty. It returns an *ExitError
+// if the process exits, or the context's error if ctx is done first.
+func (p *Process) Receive(ctx context.Context) (Term, error) {
+	return p.ReceiveFunc(ctx, func(Term) bool { return true })
+}
+
+// ReceiveFunc is like Receive, but it returns the oldest message for
+// which match returns true and leaves the others in the mailbox, like
+// a receive expression with patterns.
+func (p *Process) ReceiveFunc(ctx context.Context, match func(Term) bool) (Term, error) {
+	for {
+		p.m.Lock()
+		if p.reason != nil {
+			p.m.Unlock()
+			return nil, &ExitError{p.reason}
+		}
+		for i, msg := range p.queue {
+			if match(msg) {
+				p.queue = append(p.queue[:i], p.queue[i+1:]...)
+				p.m.Unlock()
+				return msg, nil
+			}
+		}
+		p.m.Unlock()
+
+		select {
+		case <-p.notify:
+		case <-p.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ReceiveMatch is like ReceiveFunc, but it receives the oldest message
+// that matches pattern, as Match does, and returns its bindings.
+func (p *Process) ReceiveMatch(ctx context.Context, pattern Term) (Term, Bindings, error) {
+	var b Bindings
+	msg, err := p.ReceiveFunc(ctx, func(t Term) bool {
+		var ok bool
+		b, ok = Match(pattern, t)
+		return ok
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return msg, b, nil
+}
+
+// Done returns a channel that is closed when p exits.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// TrapExits sets whether exit signals from linked processes are
+// delivered to p as {'EXIT', Pid, Reason} messages instead of making
+// it exit, like process_flag(trap_exit, On).
+func (p *Process) TrapExits(on bool) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.trapExit = on
+}
+
+// Link links p to the process pid, like link/1. When either of them
+// exits, the other receives an exit signal with the same reason. If
+// pid doesn't exist, p receives an exit signal with the reason noproc
+// immediately.
+func (p *Process) Link(pid Pid) {
+	if pid == p.pid {
+		return
+	}
+
+	if other, ok := p.node.lookup(pid); ok {
+		unlock := lockPair(p, other)
+		exited, otherExited := p.reason != nil, other.reason != nil
+		if !exited && !otherExited {
+			p.links[pid] = struct{}{}
+			other.links[p.pid] = struct{}{}
+		}
+		unlock()
+
+		if exited || !otherExited {
+			return
+		}
+	}
+	p.exitSignal(pid, Atom("noproc"))
+}
+
+// lockPair locks both a and b, always in the same order so that two
+// goroutines locking the same pair can't deadlock. It returns a
+// function that unlocks them.
+func lockPair(a, b *Process) (unlock func()) {
+	if a == b {
+		a.m.Lock()
+		return a.m.Unlock
+	}
+	if Compare(a.pid, b.pid) > 0 {
+		a, b = b, a
+	}
+	a.m.Lock()
+	b.m.Lock()
+	return func() {
+		b.m.Unlock()
+		a.m.Unlock()
+	}
+}
+
+// Unlink removes the link between p and pid, like unlink/1.
+func (p *Process) Unlink(pid Pid) {
+	p.m.Lock()
+	delete(p.links, pid)
+	p.m.Unlock()
+
+	if other, ok := p.node.lookup(pid); ok {
+		other.m.Lock()
+		delete(other.links, p.pid)
+		other.m.Unlock()
+	}
+}
+
+// Monitor starts monitoring the process pid, like
+// monitor(process, Pid). When it exits, p receives the message
+// {'DOWN', Ref, process, Pid, Reason}, where Ref is the returned
+// reference. If pid doesn't exist, the message is sent immediately
+// with the reason noproc.
+func (p *Process) Monitor(pid Pid) Ref {
+	ref := p.node.MakeRef()
+
+	k := refKey(ref)
+
+	if other, ok := p.node.lookup(pid); ok {
+		unlock := lockPair(p, other)
+		exited, otherExited := p.reason != nil, other.reason != nil
+		if !exited && !otherExited {
+			other.monitors[k] = monitor{ref, p.pid}
+			p.watching[k] = pid
+		}
+		unlock()
+
+		if exited || !otherExited {
+			return ref
+		}
+	}
+
+	p.deliver(downMessage(ref, pid, Atom("noproc")))
+	return ref
+}
+
+// Demonitor stops the monitor created by Monitor, like demonitor/1. It
+// doesn't remove a DOWN message that has already been delivered.
+func (p *Process) Demonitor(ref Ref) {
+	k := refKey(ref)
+
+	p.m.Lock()
+	pid, ok := p.watching[k]
+	delete(p.watching, k)
+	p.m.Unlock()
+
+	if !ok {
+		return
+	}
+	if other, ok := p.node.lookup(pid); ok {
+		other.m.Lock()
+		delete(other.monitors, k)
+		other.m.Unlock()
+	}
+}
+
+func downMessage(ref Ref, pid Pid, reason Term) Tuple {
+	return Tuple{Atom("DOWN"), ref, Atom("process"), pid, reason}
+}
+
+// exitSignal handles an exit signal sent to p by a linked process. The
+// reason kill can't be trapped.
+func (p *Process) exitSignal(from Pid, reason Term) {
+	p.m.Lock()
+	trap := p.trapExit
+	p.m.Unlock()
+
+	switch {
+	case reason == Atom("kill"):
+		p.Exit(reason)
+	case trap:
+		p.deliver(Tuple{Atom("EXIT"), from, reason})
+	case !Equal(reason, Atom("normal")):
+		p.Exit(reason)
+	}
+}
+
+// Exit makes p exit with the given reason, which it sends to its links
+// and monitors. Messages left in its mailbox are discarded, and its
+// receive methods return an *ExitError from then on. Exit does nothing
+// if p has already exited. A nil reason is the same as normal.
+//
+// As with exit(Pid, kill), the reason kill makes p exit with the reason
+// killed, which is what its links and monitors receive, so that a kill
+// doesn't spread to processes that trap exits.
+func (p *Process) Exit(reason Term) {
+	switch {
+	case reason == nil:
+		reason = Atom("normal")
+	case reason == Atom("kill"):
+		reason = Atom("killed")
+	}
+
+	p.m.Lock()
+	if p.reason != nil {
+		p.m.Unlock()
+		return
+	}
+	p.reason = reason
+	p.queue = nil
+	links, monitors, watching := p.links, p.monitors, p.watching
+	p.links, p.monitors, p.watching = nil, nil, nil
+	close(p.done)
+	p.m.Unlock()
+
+	p.node.remove(p)
+
+	for pid := range links {
+		if other, ok := p.node.lookup(pid); ok {
+			other.m.Lock()
+			delete(other.links, p.pid)
+			other.m.Unlock()
+			other.exitSignal(p.pid, reason)
+		}
+	}
+	for _, mon := range monitors {
+		if watcher, ok := p.node.lookup(mon.watcher); ok {
+			watcher.m.Lock()
+			delete(watcher.watching, refKey(mon.ref))
+			watcher.m.Unlock()
+			watcher.deliver(downMessage(mon.ref, p.pid, reason))
+		}
+	}
+	for k, pid := range watching {
+		if other, ok := p.node.lookup(pid); ok {
+			other.m.Lock()
+			delete(other.monitors, k)
+			other.m.Unlock()
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package etf
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestNodePids(t *testing.T) {
+	n := NewNode("go@localhost", 7)
+	n.nextID = 1<<15 - 2
+
+	a, b, c := n.NewProcess(), n.NewProcess(), n.NewProcess()
+	exp := []Pid{
+		{Node: "go@localhost", Id: 1<<15 - 1, Serial: 0, Creation: 7},
+		{Node: "go@localhost", Id: 0, Serial: 1, Creation: 7},
+		{Node: "go@localhost", Id: 1, Serial: 1, Creation: 7},
+	}
+	for i, p := range []*Process{a, b, c} {
+		if p.Pid() != exp[i] {
+			t.Errorf("%d: expected %v, got %#v", i, exp[i], p.Pid())
+		}
+	}
+
+	r1, r2 := n.MakeRef(), n.MakeRef()
+	if Equal(r1, r2) || len(r1.Id) != 3 || r1.Node != "go@localhost" || r1.Creation != 7 {
+		t.Errorf("unexpected references %#v, %#v", r1, r2)
+	}
+}
+
+func TestNodeSend(t *testing.T) {
+	ctx := testContext(t)
+	n := NewNode("go@localhost", 1)
+
+	echo := n.Spawn(func(p *Process) error {
+		for {
+			_, b, err := p.ReceiveMatch(ctx, MustParsePattern("{From, Msg}"))
+			if err != nil {
+				return err
+			}
+			from, _ := Bound[Pid](b, "From")
+			if err := p.Send(from, b["Msg"]); err != nil {
+				return err
+			}
+		}
+	})
+	if err := n.Register("echo", echo); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Register("echo", echo); err == nil {
+		t.Error("registered a name twice")
+	}
+	if pid, ok := n.WhereIs("echo"); !ok || pid != echo {
+		t.Errorf("WhereIs: got %v, %v", pid, ok)
+	}
+
+	self := n.NewProcess()
+	n.Send(self.Pid(), Atom("first"))
+	if err := n.Send(Atom("echo"), Tuple{self.Pid(), Atom("hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := self.ReceiveFunc(ctx, func(t Term) bool { return Equal(t, Atom("hello")) })
+	if err != nil || !Equal(msg, Atom("hello")) {
+		t.Errorf("selective receive: got %v, %v", msg, err)
+	}
+	if msg, err := self.Receive(ctx); err != nil || !Equal(msg, Atom("first")) {
+		t.Errorf("receive: got %v, %v", msg, err)
+	}
+
+	if err := n.Send(Atom("nobody"), 1); !errors.Is(err, ErrNoProcess) {
+		t.Errorf("expected ErrNoProcess, got %v", err)
+	}
+	if err := n.Send(Pid{Node: "other@host"}, 1); err == nil {
+		t.Error("sent to a remote pid")
+	}
+
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := self.Receive(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
+
+func TestNodeLinks(t *testing.T) {
+	ctx := testContext(t)
+	n := NewNode("go@localhost", 1)
+
+	trapper := n.NewProcess()
+	trapper.TrapExits(true)
+	a := n.NewProcess()
+	b := n.NewProcess()
+
+	trapper.Link(a.Pid())
+	a.Link(b.Pid())
+
+	b.Exit(Atom("crash"))
+	<-a.Done()
+
+	if _, err := a.Receive(ctx); !errors.As(err, new(*ExitError)) {
+		t.Errorf("expected ExitError, got %v", err)
+	}
+	msg, err := trapper.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(msg, Tuple{Atom("EXIT"), a.Pid(), Atom("crash")}) {
+		t.Errorf("unexpected message %v", msg)
+	}
+
+	c := n.NewProcess()
+	c.Link(trapper.Pid())
+	c.Exit(Atom("normal"))
+	msg, err = trapper.Receive(ctx)
+	if err != nil || !Equal(msg, Tuple{Atom("EXIT"), c.Pid(), Atom("normal")}) {
+		t.Errorf("unexpected message %v, %v", msg, err)
+	}
+
+	trapper.Link(c.Pid())
+	msg, err = trapper.Receive(ctx)
+	if err != nil || !Equal(msg, Tuple{Atom("EXIT"), c.Pid(), Atom("noproc")}) {
+		t.Errorf("unexpected message %v, %v", msg, err)
+	}
+
+	// A normal exit doesn't kill processes that don't trap exits.
+	d, e := n.NewProcess(), n.NewProcess()
+	d.Link(e.Pid())
+	e.Exit(nil)
+	select {
+	case <-d.Done():
+		t.Error("normal exit killed a linked process")
+	default:
+	}
+}
+
+func TestNodeMonitors(t *testing.T) {
+	ctx := testContext(t)
+	n := NewNode("go@localhost", 1)
+
+	watcher := n.NewProcess()
+	pid := n.Spawn(func(p *Process) error {
+		_, err := p.Receive(ctx)
+		if err != nil {
+			return err
+		}
+		return &ExitError{Atom("done")}
+	})
+	ref := watcher.Monitor(pid)
+	n.Send(pid, Atom("go"))
+
+	msg, err := watcher.Receive(ctx)
+	if err != nil || !Equal(msg, downMessage(ref, pid, Atom("done"))) {
+		t.Errorf("unexpected message %v, %v", msg, err)
+	}
+
+	failed := n.Spawn(func(p *Process) error { return errors.New("oops") })
+	ref = watcher.Monitor(failed)
+	msg, err = watcher.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if down := msg.(Tuple); !Equal(down[1], ref) || !(Equal(down[4], []byte("oops")) || Equal(down[4], Atom("noproc"))) {
+		t.Errorf("unexpected message %v", msg)
+	}
+
+	other := n.NewProcess()
+	ref = watcher.Monitor(other.Pid())
+	watcher.Demonitor(ref)
+	other.Exit(Atom("bye"))
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if msg, err := watcher.Receive(short); err == nil {
+		t.Errorf("received %v after demonitor", msg)
+	}
+}
+
+func TestNodeKill(t *testing.T) {
+	ctx := testContext(t)
+	n := NewNode("go@localhost", 1)
+
+	trapper := n.NewProcess()
+	trapper.TrapExits(true)
+	linked := n.NewProcess()
+	victim := n.NewProcess()
+	trapper.Link(victim.Pid())
+	linked.Link(victim.Pid())
+
+	victim.Exit(Atom("kill"))
+	msg, err := trapper.Receive(ctx)
+	if err != nil || !Equal(msg, Tuple{Atom("EXIT"), victim.Pid(), Atom("killed")}) {
+		t.Errorf("unexpected message %v, %v", msg, err)
+	}
+	<-linked.Done()
+	var exit *ExitError
+	if _, err := linked.Receive(ctx); !errors.As(err, &exit) || !Equal(exit.Reason, Atom("killed")) {
+		t.Errorf("expected killed, got %v", err)
+	}
+
+	// kill can't be trapped.
+	trapper.exitSignal(victim.Pid(), Atom("kill"))
+	if _, err := trapper.Receive(ctx); !errors.As(err, &exit) || !Equal(exit.Reason, Atom("killed")) {
+		t.Errorf("expected killed, got %v", err)
+	}
+}
+
+func TestNodeExitRace(t *testing.T) {
+	ctx := testContext(t)
+	n := NewNode("go@localhost", 1)
+
+	for range 200 {
+		watcher := n.NewProcess()
+		watcher.TrapExits(true)
+		other := n.NewProcess()
+
+		go other.Exit(Atom("bye"))
+		watcher.Monitor(other.Pid())
+		watcher.Link(other.Pid())
+
+		// Whether other exits before or after, the watcher gets a DOWN
+		// message and an EXIT message, and keeps no stale state.
+		for range 2 {
+			if _, err := watcher.Receive(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		watcher.m.Lock()
+		if len(watcher.links) != 0 || len(watcher.watching) != 0 {
+			t.Errorf("stale links %v or monitors %v", watcher.links, watcher.watching)
+		}
+		watcher.m.Unlock()
+		watcher.Exit(nil)
+	}
+}
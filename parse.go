@@ -0,0 +1,665 @@
+package etf
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseTerm parses a single term written in Erlang syntax, optionally
+// followed by a period. The result uses the same Go types that
+// Decoder.Decode would produce for the output of term_to_binary, so,
+// for example, "abc" and [97, 98, 99] both parse as the Go string
+// "abc", since that is how STRING_EXT decodes, and true parses as a
+// bool.
+func ParseTerm(s string) (Term, error) {
+	p := parser{s: s}
+	return p.single()
+}
+
+// single parses the entire input as a single term.
+func (p *parser) single() (Term, error) {
+	t, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.peek() == '.' {
+		p.pos++
+		p.skipSpace()
+	}
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q after term", p.peek())
+	}
+	return t, nil
+}
+
+// ParseTerms parses a sequence of terms written in Erlang syntax, each
+// of which is terminated by a period, in the same format that
+// file:consult/1 reads.
+func ParseTerms(s string) ([]Term, error) {
+	p := parser{s: s}
+
+	var terms []Term
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return terms, nil
+		}
+
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("."); err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+}
+
+type parser struct {
+	s       string
+	pos     int
+	pattern bool
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	c, _ := utf8.DecodeRuneInString(p.s[p.pos:])
+	return c
+}
+
+func (p *parser) next() rune {
+	if p.eof() {
+		return 0
+	}
+	c, size := utf8.DecodeRuneInString(p.s[p.pos:])
+	p.pos += size
+	return c
+}
+
+func (p *parser) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(p.s[p.pos:], prefix)
+}
+
+// skipSpace skips whitespace and comments.
+func (p *parser) skipSpace() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\n', '\r', '\f', '\v':
+			p.pos++
+		case '%':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) expect(tok string) error {
+	p.skipSpace()
+	if !p.hasPrefix(tok) {
+		if p.eof() {
+			return p.errorf("expected %q, got end of input", tok)
+		}
+		return p.errorf("expected %q, got %q", tok, p.peek())
+	}
+	p.pos += len(tok)
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	line := 1 + strings.Count(p.s[:p.pos], "\n")
+	col := 1 + utf8.RuneCountInString(p.s[strings.LastIndexByte(p.s[:p.pos], '\n')+1:p.pos])
+	return &ErrSyntax{Line: line, Column: col, msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) term() (Term, error) {
+	p.skipSpace()
+	switch c := p.peek(); {
+	case p.eof():
+		return nil, p.errorf("unexpected end of input")
+	case c == '{':
+		p.pos++
+		elems, err := p.elements('}')
+		return Tuple(elems), err
+	case c == '[':
+		return p.list()
+	case p.hasPrefix("<<"):
+		return p.binary()
+	case p.hasPrefix("#{"):
+		return p.mapTerm()
+	case c == '"':
+		s, err := p.strings()
+		return charlist(s), err
+	case c == '\'':
+		p.pos++
+		s, err := p.quoted('\'')
+		return newAtom([]byte(string(s))), err
+	case c == '$':
+		p.pos++
+		r, err := p.char()
+		return int(r), err
+	case c >= '0' && c <= '9', c == '-', c == '+':
+		return p.number()
+	case c >= 'a' && c <= 'z':
+		start := p.pos
+		for !p.eof() && isIdentChar(p.peek()) {
+			p.pos++
+		}
+		return newAtom([]byte(p.s[start:p.pos])), nil
+	case p.pattern && (c == '_' || c >= 'A' && c <= 'Z'):
+		start := p.pos
+		for !p.eof() && isIdentChar(p.peek()) {
+			p.pos++
+		}
+		if v := p.s[start:p.pos]; v != "_" {
+			return Var(v), nil
+		}
+		return Any, nil
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+// elements parses a comma separated sequence of terms ending with end.
+func (p *parser) elements(end rune) ([]Term, error) {
+	elems := []Term{}
+	p.skipSpace()
+	if p.peek() == end {
+		p.pos++
+		return elems, nil
+	}
+
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, t)
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case end:
+			p.pos++
+			return elems, nil
+		default:
+			return nil, p.errorf("expected ',' or %q", end)
+		}
+	}
+}
+
+func (p *parser) list() (Term, error) {
+	p.pos++ // [
+	elems := List{}
+	p.skipSpace()
+	if p.peek() == ']' {
+		p.pos++
+		return elems, nil
+	}
+
+	var tail Term = List{}
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, t)
+
+		p.skipSpace()
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		if p.peek() == '|' {
+			p.pos++
+			if tail, err = p.term(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	switch t := tail.(type) {
+	case List:
+		elems = append(elems, t...)
+	case string:
+		for i := 0; i < len(t); i++ {
+			elems = append(elems, int(t[i]))
+		}
+	case ImproperList:
+		return ImproperList{append(elems, t.Elements...), t.Tail}, nil
+	default:
+		return ImproperList{elems, tail}, nil
+	}
+	return properList(elems), nil
+}
+
+// properList returns the term that term_to_binary and Decode would
+// turn a list into, which is a Go string if every element is a
+// Latin-1 character code.
+func properList(elems List) Term {
+	if len(elems) == 0 || len(elems) > math.MaxUint16 {
+		return elems
+	}
+
+	b := make([]byte, len(elems))
+	for i, e := range elems {
+		c, ok := e.(int)
+		if !ok || c < 0 || c > math.MaxUint8 {
+			return elems
+		}
+		b[i] = byte(c)
+	}
+	return string(b)
+}
+
+// charlist returns the term for a string literal.
+func charlist(s []rune) Term {
+	elems := make(List, len(s))
+	for i, c := range s {
+		elems[i] = int(c)
+	}
+	return properList(elems)
+}
+
+func (p *parser) mapTerm() (Term, error) {
+	p.pos += 2 // #{
+	m := Map{}
+	p.skipSpace()
+	if p.peek() == '}' {
+		p.pos++
+		return m, nil
+	}
+
+	for {
+		start := p.pos
+		k, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if hasVars(k) {
+			p.pos = start
+			p.skipSpace()
+			return nil, p.errorf("variables aren't allowed in map keys")
+		}
+		p.skipSpace()
+		if p.pattern && p.hasPrefix(":=") {
+			p.pos += 2
+		} else if err := p.expect("=>"); err != nil {
+			return nil, err
+		}
+		v, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+
+		// Later keys replace earlier ones, as they do in Erlang.
+		replaced := false
+		for i := range m {
+			if ExactEqual(m[i].Key, k) {
+				m[i].Value = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			m = append(m, MapEntry{k, v})
+		}
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return m, nil
+		default:
+			return nil, p.errorf("expected ',' or '}'")
+		}
+	}
+}
+
+// binary parses a binary. Segments can be strings, integers or
+// characters with an optional size in bits and an optional /utf8
+// type.
+func (p *parser) binary() (Term, error) {
+	p.pos += 2 // <<
+	b := []byte{}
+	p.skipSpace()
+	if p.hasPrefix(">>") {
+		p.pos += 2
+		return b, nil
+	}
+
+	for {
+		p.skipSpace()
+		var values []*big.Int
+		var isString bool
+		switch c := p.peek(); {
+		case c == '"':
+			s, err := p.strings()
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range s {
+				values = append(values, big.NewInt(int64(r)))
+			}
+			isString = true
+		case c == '$':
+			p.pos++
+			r, err := p.char()
+			if err != nil {
+				return nil, err
+			}
+			values = []*big.Int{big.NewInt(int64(r))}
+		case c >= '0' && c <= '9', c == '-':
+			n, err := p.number()
+			if err != nil {
+				return nil, err
+			}
+			switch n := n.(type) {
+			case int:
+				values = []*big.Int{big.NewInt(int64(n))}
+			case int64:
+				values = []*big.Int{big.NewInt(n)}
+			case *big.Int:
+				values = []*big.Int{n}
+			default:
+				return nil, p.errorf("unsupported binary segment value %v", n)
+			}
+		default:
+			return nil, p.errorf("unexpected %q in binary", c)
+		}
+
+		size := 8
+		if p.peek() == ':' {
+			p.pos++
+			n, err := p.number()
+			if err != nil {
+				return nil, err
+			}
+			if size, _ = n.(int); size <= 0 || size%8 != 0 || isString {
+				return nil, p.errorf("unsupported binary segment size %v", n)
+			}
+		}
+
+		utf8Type := false
+		if p.peek() == '/' {
+			p.pos++
+			switch {
+			case p.hasPrefix("utf8"):
+				p.pos += 4
+				utf8Type = true
+			case p.hasPrefix("latin1"):
+				p.pos += 6
+			default:
+				return nil, p.errorf("unsupported binary segment type")
+			}
+		}
+
+		for _, v := range values {
+			switch {
+			case utf8Type:
+				if !v.IsInt64() || v.Int64() > utf8.MaxRune || !utf8.ValidRune(rune(v.Int64())) {
+					return nil, p.errorf("invalid code point %v in binary", v)
+				}
+				b = utf8.AppendRune(b, rune(v.Int64()))
+			default:
+				// Like Erlang, keep only the low size bits of the
+				// value, in two's complement.
+				var octet big.Int
+				for shift := size - 8; shift >= 0; shift -= 8 {
+					octet.Rsh(v, uint(shift))
+					b = append(b, byte(octet.And(&octet, byteMask).Uint64()))
+				}
+			}
+		}
+
+		p.skipSpace()
+		switch {
+		case p.peek() == ',':
+			p.pos++
+		case p.hasPrefix(">>"):
+			p.pos += 2
+			return b, nil
+		default:
+			return nil, p.errorf("expected ',' or \">>\"")
+		}
+	}
+}
+
+var byteMask = big.NewInt(0xFF)
+
+// strings parses one or more adjacent string literals, which Erlang
+// concatenates.
+func (p *parser) strings() ([]rune, error) {
+	var s []rune
+	for {
+		p.pos++ // "
+		part, err := p.quoted('"')
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, part...)
+
+		p.skipSpace()
+		if p.peek() != '"' {
+			return s, nil
+		}
+	}
+}
+
+// quoted parses the rest of a quoted atom or string after the opening
+// quote.
+func (p *parser) quoted(q rune) ([]rune, error) {
+	s := []rune{}
+	for {
+		if p.eof() {
+			return nil, p.errorf("unterminated quoted literal")
+		}
+		switch c := p.next(); c {
+		case q:
+			return s, nil
+		case '\\':
+			c, err := p.escape()
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, c)
+		default:
+			s = append(s, c)
+		}
+	}
+}
+
+// char parses a character literal after the $.
+func (p *parser) char() (rune, error) {
+	if p.eof() {
+		return 0, p.errorf("unexpected end of input")
+	}
+	if c := p.next(); c != '\\' {
+		return c, nil
+	}
+	return p.escape()
+}
+
+var escapeChars = map[rune]rune{
+	'b': '\b', 'd': '\x7f', 'e': '\x1b', 'f': '\f', 'n': '\n',
+	'r': '\r', 's': ' ', 't': '\t', 'v': '\v',
+}
+
+// escape parses an escape sequence after the backslash.
+func (p *parser) escape() (rune, error) {
+	if p.eof() {
+		return 0, p.errorf("unexpected end of input")
+	}
+
+	c := p.next()
+	switch {
+	case escapeChars[c] != 0:
+		return escapeChars[c], nil
+
+	case c >= '0' && c <= '7':
+		v := c - '0'
+		for i := 0; i < 2 && p.peek() >= '0' && p.peek() <= '7'; i++ {
+			v = v*8 + p.next() - '0'
+		}
+		return v, nil
+
+	case c == 'x':
+		var digits string
+		if p.peek() == '{' {
+			end := strings.IndexByte(p.s[p.pos:], '}')
+			if end < 0 {
+				return 0, p.errorf("unterminated \\x{ escape")
+			}
+			digits = p.s[p.pos+1 : p.pos+end]
+			p.pos += end + 1
+		} else {
+			if len(p.s)-p.pos < 2 {
+				return 0, p.errorf("incomplete \\x escape")
+			}
+			digits = p.s[p.pos : p.pos+2]
+			p.pos += 2
+		}
+		v, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil {
+			return 0, p.errorf("bad \\x escape %q", digits)
+		}
+		return rune(v), nil
+
+	case c == '^':
+		return p.next() & 0x1f, nil
+	}
+
+	return c, nil
+}
+
+// number parses an integer or a float, including based integers, such
+// as 16#FF.
+func (p *parser) number() (Term, error) {
+	start := p.pos
+	neg := false
+	switch p.peek() {
+	case '-':
+		neg = true
+		p.pos++
+	case '+':
+		p.pos++
+	}
+
+	digits := p.digits(10)
+	if digits == "" {
+		return nil, p.errorf("expected digit")
+	}
+
+	if p.peek() == '#' {
+		base, err := strconv.Atoi(digits)
+		if err != nil || base < 2 || base > 36 {
+			return nil, p.errorf("bad integer base %q", digits)
+		}
+		p.pos++
+		if digits = p.digits(base); digits == "" {
+			return nil, p.errorf("expected base %d digit", base)
+		}
+		return p.integer(digits, base, neg)
+	}
+
+	if p.peek() == '.' && p.pos+1 < len(p.s) && isDigit(rune(p.s[p.pos+1]), 10) {
+		p.pos++
+		p.digits(10)
+		if c := p.peek(); c == 'e' || c == 'E' {
+			p.pos++
+			if c := p.peek(); c == '-' || c == '+' {
+				p.pos++
+			}
+			if p.digits(10) == "" {
+				return nil, p.errorf("expected exponent")
+			}
+		}
+
+		text := strings.ReplaceAll(p.s[start:p.pos], "_", "")
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, p.errorf("bad float %q", text)
+		}
+		return f, nil
+	}
+
+	return p.integer(digits, 10, neg)
+}
+
+func (p *parser) integer(digits string, base int, neg bool) (Term, error) {
+	v, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return nil, p.errorf("bad integer %q", digits)
+	}
+	if neg {
+		v.Neg(v)
+	}
+	return bigIntTerm(v), nil
+}
+
+// digits consumes digits in the given base, along with any
+// underscores separating them, and returns them without the
+// underscores.
+func (p *parser) digits(base int) string {
+	var buf strings.Builder
+	for !p.eof() {
+		c := p.peek()
+		if c == '_' && buf.Len() > 0 && p.pos+1 < len(p.s) && isDigit(rune(p.s[p.pos+1]), base) {
+			p.pos++
+			continue
+		}
+		if !isDigit(c, base) {
+			break
+		}
+		buf.WriteRune(c)
+		p.pos++
+	}
+	return buf.String()
+}
+
+func isDigit(c rune, base int) bool {
+	var v int
+	switch {
+	case c >= '0' && c <= '9':
+		v = int(c - '0')
+	case c >= 'a' && c <= 'z':
+		v = int(c-'a') + 10
+	case c >= 'A' && c <= 'Z':
+		v = int(c-'A') + 10
+	default:
+		return false
+	}
+	return v < base
+}
+
+// ErrSyntax is returned when parsing a term fails.
+type ErrSyntax struct {
+	Line   int
+	Column int
+	msg    string
+}
+
+func (e *ErrSyntax) Error() string {
+	return fmt.Sprintf("parse: %d:%d: %s", e.Line, e.Column, e.msg)
+}
@@ -0,0 +1,137 @@
+package etf
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestParseTerm(t *testing.T) {
+	test := func(in string, exp Term) {
+		v, err := ParseTerm(in)
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+		} else if Compare(v, exp) != 0 {
+			t.Errorf("%s: expected %v, got %v", in, exp, v)
+		}
+	}
+
+	test("ok", Atom("ok"))
+	test("node@host", Atom("node@host"))
+	test("'hello world'", Atom("hello world"))
+	test(`'it\'s'`, Atom("it's"))
+	test("true", true)
+	test("'false'", false)
+
+	test("42", 42)
+	test("-42", -42)
+	test("1_000_000", 1000000)
+	test("16#FF", 255)
+	test("-2#1010", -10)
+	test("36#zz", 1295)
+	test("$a", 97)
+	test(`$\n`, 10)
+	test("123456789012345678901234567890", func() *big.Int {
+		v, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+		return v
+	}())
+
+	test("1.5", 1.5)
+	test("-2.0e-3", -0.002)
+	test("1.0E10", 1e10)
+
+	test(`"abc"`, "abc")
+	test(`"ab" "c"`, "abc")
+	test(`""`, List{})
+	test(`"\x{100}"`, List{256})
+	test(`"\101\x42\^c"`, "AB\x03")
+	test("[97, 98, 99]", "abc")
+	test("[1, a]", List{1, Atom("a")})
+	test("[]", List{})
+	test("[1, 2 | [3]]", "\x01\x02\x03")
+	test("[a | b]", ImproperList{List{Atom("a")}, Atom("b")})
+	test("[a | [b | c]]", ImproperList{List{Atom("a"), Atom("b")}, Atom("c")})
+
+	test("<<>>", []byte{})
+	test(`<<"bin">>`, []byte("bin"))
+	test("<<1, 2, 3>>", []byte{1, 2, 3})
+	test(`<<"é"/utf8, $a, 256:16>>`, []byte{0xc3, 0xa9, 'a', 1, 0})
+	test("<<4294967296:64, -1:16, 258:8>>", []byte{0, 0, 0, 1, 0, 0, 0, 0, 0xff, 0xff, 2})
+	test("<<18446744073709551617:72>>", []byte{1, 0, 0, 0, 0, 0, 0, 0, 1})
+
+	test("{}", Tuple{})
+	test("{ok, {1, [2]}}", Tuple{Atom("ok"), Tuple{1, List{2}}})
+	test("#{}", Map{})
+	test(`#{a => 1, "k" => {x}}`, Map{{Atom("a"), 1}, {"k", Tuple{Atom("x")}}})
+	test("#{a => 1, a => 2}", Map{{Atom("a"), 2}})
+	test("#{1 => a, 1.0 => b}", Map{{1, Atom("a")}, {1.0, Atom("b")}})
+
+	test("  {ok, % comment\n 1}.  ", Tuple{Atom("ok"), 1})
+}
+
+func TestParseTermDecode(t *testing.T) {
+	c := new(Context)
+	test := func(in string) {
+		v, err := ParseTerm(in)
+		if err != nil {
+			t.Fatal(in, err)
+		}
+
+		w := new(bytes.Buffer)
+		if err := c.Encoder(w).Encode(v); err != nil {
+			t.Fatal(in, err)
+		}
+		d, err := c.Decoder(w).Decode()
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		if Compare(v, d) != 0 {
+			t.Errorf("%s: parsed %v, decoded %v", in, v, d)
+		}
+	}
+
+	test(`{reply, [1, 2 | tail], #{<<"k">> => "v"}, 3.5}`)
+	test(`[{a, 1}, {b, -70000}, 18446744073709551616]`)
+}
+
+func TestParseTerms(t *testing.T) {
+	v, err := ParseTerms("{a, 1}.\n% comment\n{b, 2.5}.\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []Term{Tuple{Atom("a"), 1}, Tuple{Atom("b"), 2.5}}
+	if len(v) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, v)
+	}
+	for i := range exp {
+		if !Equal(v[i], exp[i]) {
+			t.Errorf("expected %v, got %v", exp[i], v[i])
+		}
+	}
+
+	if _, err := ParseTerms("{a, 1}"); err == nil {
+		t.Error("err == nil")
+	}
+}
+
+func TestParseTermError(t *testing.T) {
+	test := func(in string, line, col int) {
+		_, err := ParseTerm(in)
+		var serr *ErrSyntax
+		if !errors.As(err, &serr) {
+			t.Errorf("%s: expected syntax error, got %v", in, err)
+		} else if serr.Line != line || serr.Column != col {
+			t.Errorf("%s: expected %d:%d, got %v", in, line, col, err)
+		}
+	}
+
+	test("", 1, 1)
+	test("{a, b", 1, 6)
+	test("[1,\n 2 3]", 2, 4)
+	test(`"abc`, 1, 5)
+	test("Var", 1, 1)
+	test("{a} b", 1, 5)
+	test("<<a>>", 1, 3)
+	test("#{a}", 1, 4)
+}
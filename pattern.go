@@ -0,0 +1,210 @@
+package etf
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Var is a pattern variable. When a pattern is matched, it matches any
+// term and binds it to its name, unless the same name has already been
+// bound, in which case it only matches a term that is ExactEqual to the
+// bound one, as in Erlang. Var("_") is the same as Any.
+type Var string
+
+func (v Var) String() string { return string(v) }
+
+type anyPattern struct{}
+
+func (anyPattern) String() string { return "_" }
+
+// Any is a pattern that matches any term without binding it.
+var Any anyPattern
+
+// Bindings holds the values bound to variables by a successful Match.
+type Bindings map[Var]Term
+
+// Bound returns the value bound to name converted to a T by As.
+func Bound[T any](b Bindings, name Var) (T, error) {
+	t, ok := b[name]
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("variable %v: %w", name, ErrNotFound)
+	}
+	v, err := As[T](t)
+	if err != nil {
+		return v, fmt.Errorf("variable %v: %w", name, err)
+	}
+	return v, nil
+}
+
+// Match matches t against pattern. It returns the variables bound by
+// the match and true if t matches, or nil and false if it doesn't.
+//
+// A pattern is a term that may contain Var and Any anywhere in it.
+// Patterns match the way that they do in Erlang:
+//
+//   - Literal terms match terms that are ExactEqual to them, so 1
+//     doesn't match 1.0.
+//   - Tuples and lists match element by element and must have the same
+//     length as the term.
+//   - An ImproperList matches a list that starts with its elements,
+//     and its Tail is matched against the rest of the list, so
+//     ImproperList{List{Var("H")}, Var("T")} is [H | T].
+//   - A Map matches any map that has all of its keys, whatever other
+//     keys it has, as long as the values match.
+//
+// Go values are interpreted as they are by Compare, so a struct
+// pattern matches a tuple of its fields.
+func Match(pattern, t Term) (Bindings, bool) {
+	m := matcher{b: Bindings{}}
+	if !m.match(pattern, t) {
+		return nil, false
+	}
+	return m.b, true
+}
+
+type matcher struct {
+	b Bindings
+}
+
+func (m *matcher) match(p, t Term) bool {
+	if n, ok := normalize(t); ok {
+		t = n
+	}
+
+	switch p := p.(type) {
+	case anyPattern:
+		return true
+
+	case Var:
+		if p == "_" {
+			return true
+		}
+		if bound, ok := m.b[p]; ok {
+			return exactEqual(bound, t)
+		}
+		m.b[p] = t
+		return true
+
+	case Tuple:
+		t, ok := t.(Tuple)
+		return ok && len(t) == len(p) && m.matchElements(p, t)
+
+	case List:
+		elems, tail, ok := listOf(t)
+		return ok && len(elems) == len(p) && termClass(tail) == classNil && m.matchElements(p, elems)
+
+	case ImproperList:
+		elems, tail, ok := listOf(t)
+		if !ok || len(elems) < len(p.Elements) {
+			return false
+		}
+		n := len(p.Elements)
+		return m.matchElements(p.Elements, elems[:n]) && m.match(p.Tail, listRest(elems[n:], tail))
+
+	case Map:
+		t, ok := t.(Map)
+		if !ok {
+			return false
+		}
+		for _, pe := range p {
+			if !m.matchEntry(pe, t) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if n, ok := normalize(p); ok {
+		switch n.(type) {
+		case Tuple, List, Map:
+			return m.match(n, t)
+		}
+	}
+	return exactEqual(p, t)
+}
+
+func (m *matcher) matchElements(p, t []Term) bool {
+	for i := range p {
+		if !m.match(p[i], t[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchEntry reports whether any entry of t matches pe. Bindings made
+// while trying entries that don't match are discarded.
+func (m *matcher) matchEntry(pe MapEntry, t Map) bool {
+	for _, te := range t {
+		saved := maps.Clone(m.b)
+		if m.match(pe.Key, te.Key) && m.match(pe.Value, te.Value) {
+			return true
+		}
+		m.b = saved
+	}
+	return false
+}
+
+// listOf returns the elements and tail of t if it is a list.
+func listOf(t Term) (elems []Term, tail Term, ok bool) {
+	switch t.(type) {
+	case List, ImproperList, string, Charlist:
+		elems, tail = listParts(t)
+		return elems, tail, true
+	}
+	return nil, nil, false
+}
+
+// exactEqual is ExactEqual, but it returns false instead of panicking
+// if either argument isn't a term.
+func exactEqual(a, b Term) bool {
+	if _, ok := normalize(a); !ok {
+		return false
+	}
+	if _, ok := normalize(b); !ok {
+		return false
+	}
+	return ExactEqual(a, b)
+}
+
+// hasVars reports whether t contains any pattern variables.
+func hasVars(t Term) bool {
+	switch t := t.(type) {
+	case Var, anyPattern:
+		return true
+	case Tuple:
+		return slices.ContainsFunc(t, hasVars)
+	case List:
+		return slices.ContainsFunc(t, hasVars)
+	case ImproperList:
+		return slices.ContainsFunc(t.Elements, hasVars) || hasVars(t.Tail)
+	case Map:
+		return slices.ContainsFunc(t, func(e MapEntry) bool { return hasVars(e.Key) || hasVars(e.Value) })
+	}
+	return false
+}
+
+// ParsePattern parses a pattern written in Erlang syntax. It accepts
+// everything that ParseTerm does, and, in addition, variables, which
+// become Var, the anonymous variable _, which becomes Any, and map
+// patterns written with :=. For example,
+//
+//	etf.ParsePattern("{reply, Ref, #{status := ok, value := V}}")
+//
+// Keys in map patterns can't contain variables.
+func ParsePattern(s string) (Term, error) {
+	p := parser{s: s, pattern: true}
+	return p.single()
+}
+
+// MustParsePattern is like ParsePattern but panics if s can't be
+// parsed. It is meant for initializing package-level patterns.
+func MustParsePattern(s string) Term {
+	t, err := ParsePattern(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
@@ -0,0 +1,88 @@
+package etf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		in      Term
+		exp     Bindings
+	}{
+		{"{ok, X}", Tuple{Atom("ok"), 1}, Bindings{"X": 1}},
+		{"{ok, _}", Tuple{Atom("ok"), 1}, Bindings{}},
+		{"{X, X}", Tuple{1, 1}, Bindings{"X": 1}},
+		{"{X, X}", Tuple{1, 1.0}, nil},
+		{"{ok, 1}", Tuple{Atom("ok"), 1.0}, nil},
+		{"{ok, X}", Tuple{Atom("ok"), 1, 2}, nil},
+		{"[H | T]", List{1, 2, 3}, Bindings{"H": 1, "T": List{2, 3}}},
+		{"[H | T]", "abc", Bindings{"H": 97, "T": List{98, 99}}},
+		{"[H | T]", List{}, nil},
+		{"[_, _ | T]", ImproperList{List{1, 2}, Atom("t")}, Bindings{"T": Atom("t")}},
+		{"[A, B]", List{1, 2, 3}, nil},
+		{"[A, B]", ImproperList{List{1, 2}, 3}, nil},
+		{"#{}", Map{{Atom("a"), 1}}, Bindings{}},
+		{"#{a := V}", Map{{Atom("b"), 2}, {Atom("a"), 1}}, Bindings{"V": 1}},
+		{"#{a := V, b := V}", Map{{Atom("a"), 1}, {Atom("b"), 2}}, nil},
+		{"#{a := V}", Map{{Atom("b"), 2}}, nil},
+		{"#{1 => V}", Map{{1.0, 2}}, nil},
+		{`{"abc", <<"bin">>}`, Tuple{List{97, 98, 99}, Binary("bin")}, Bindings{}},
+		{"{true}", Tuple{Atom("true")}, Bindings{}},
+	}
+	for _, test := range tests {
+		b, ok := Match(MustParsePattern(test.pattern), test.in)
+		if ok != (test.exp != nil) {
+			t.Errorf("%s, %v: expected match %v", test.pattern, test.in, test.exp != nil)
+			continue
+		}
+		if len(b) != len(test.exp) {
+			t.Errorf("%s, %v: expected %v, got %v", test.pattern, test.in, test.exp, b)
+		}
+		for k, v := range test.exp {
+			if !Equal(b[k], v) {
+				t.Errorf("%s, %v: %v: expected %v, got %v", test.pattern, test.in, k, v, b[k])
+			}
+		}
+	}
+}
+
+func TestMatchGo(t *testing.T) {
+	type reply struct {
+		Tag   Atom
+		Value Var
+	}
+	b, ok := Match(reply{Atom("reply"), "V"}, Tuple{Atom("reply"), 5})
+	if !ok {
+		t.Fatal("no match")
+	}
+
+	v, err := Bound[int](b, "V")
+	if err != nil || v != 5 {
+		t.Errorf("got %v, %v", v, err)
+	}
+	if _, err := Bound[int](b, "W"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	if _, ok := Match(Tuple{Var("_"), Any}, Tuple{1, 2}); !ok {
+		t.Error("wildcards didn't match")
+	}
+	if _, ok := Match(Atom("a"), make(chan int)); ok {
+		t.Error("matched a non-term")
+	}
+}
+
+func TestParsePatternError(t *testing.T) {
+	for _, in := range []string{"#{K := 1}", "{ok, X"} {
+		_, err := ParsePattern(in)
+		var serr *ErrSyntax
+		if !errors.As(err, &serr) {
+			t.Errorf("%s: expected syntax error, got %v", in, err)
+		}
+	}
+	if _, err := ParseTerm("{ok, X}"); err == nil {
+		t.Error("ParseTerm accepted a variable")
+	}
+}
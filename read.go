@@ -3,150 +3,642 @@ package etf
 import (
 	"bufio"
 	"bytes"
+	"compress/zlib"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"math"
 	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 var (
-	ErrFloatScan = fmt.Errorf("read: failed to sscanf float")
-	be           = binary.BigEndian
-	bTrue        = []byte("true")
-	bFalse       = []byte("false")
+	ErrFloatScan       = fmt.Errorf("read: failed to sscanf float")
+	ErrIntegerOverflow = fmt.Errorf("read: integer overflow")
+	ErrVersion         = fmt.Errorf("read: bad version byte")
+	be                 = binary.BigEndian
+	bTrue              = []byte("true")
+	bFalse             = []byte("false")
 )
 
 type Decoder struct {
-	c *Context
-	r *bufio.Reader
+	c    *Context
+	r    *bufio.Reader
+	cr   countingReader
+	opts DecoderOptions
+
+	// path holds the positions of the terms currently being decoded
+	// inside of their parents, for errors.
+	path []pathElem
+
+	// atomRefs maps the indexes used by ATOM_CACHE_REF to indexes in
+	// the atom cache of c. It is per connection, so it isn't shared.
+	atomRefs []int
+}
+
+// Reset makes the Decoder read from r, keeping its options and
+// discarding any buffered data and atom cache references. It allows a
+// Decoder and its buffer to be reused instead of allocating new ones.
+func (d *Decoder) Reset(r io.Reader) {
+	d.cr = countingReader{r: r}
+	d.r.Reset(&d.cr)
+	d.atomRefs = d.atomRefs[:0]
+	d.path = d.path[:0]
+}
+
+// InputOffset returns the number of bytes read from the reader since
+// the Decoder was created or last reset.
+func (d *Decoder) InputOffset() int64 {
+	return d.cr.n - int64(d.r.Buffered())
+}
+
+// countingReader counts the bytes read from an io.Reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// A DecodeError is returned when a term can't be decoded. It describes
+// the innermost term that was being decoded when the error occurred.
+type DecodeError struct {
+	// Offset is the offset of the term's tag in the input.
+	Offset int64
+
+	// Path is the position of the term inside of the top-level term,
+	// such as "{1}[0]#{2}.value" for the value of the third entry of
+	// the map that is the first element of the list that is the second
+	// element of a tuple. Indexes start at 0. The tail of an improper
+	// list is "|". It is empty for the top-level term.
+	Path string
+
+	// Tag is the term's tag.
+	Tag byte
+
+	// Err is the cause of the error.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "top level"
+	}
+	return fmt.Sprintf("read: offset %d: %s (%s): %v", e.Offset, path, tagName(e.Tag), e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// A pathElem is the position of a term inside of its parent.
+type pathElem struct {
+	kind  byte
+	index int
+	name  string
+}
+
+const (
+	pathTuple    = '{'
+	pathList     = '['
+	pathTail     = '|'
+	pathMapKey   = 'k'
+	pathMapValue = 'v'
+	pathField    = '.'
+)
+
+func formatPath(path []pathElem) string {
+	var sb strings.Builder
+	for _, elem := range path {
+		switch elem.kind {
+		case pathTuple:
+			fmt.Fprintf(&sb, "{%d}", elem.index)
+		case pathList:
+			fmt.Fprintf(&sb, "[%d]", elem.index)
+		case pathTail:
+			sb.WriteByte('|')
+		case pathMapKey:
+			fmt.Fprintf(&sb, "#{%d}.key", elem.index)
+		case pathMapValue:
+			fmt.Fprintf(&sb, "#{%d}.value", elem.index)
+		case pathField:
+			sb.WriteString(".")
+			sb.WriteString(elem.name)
+		}
+	}
+	return sb.String()
+}
+
+// maxScratch is the capacity above which scratch buffers aren't
+// returned to scratchPool, so that a single large term doesn't pin a
+// large buffer.
+const maxScratch = 64 << 10
+
+// maxPrealloc is the largest number of elements that are allocated for
+// a tuple, list or map before they are read. Larger terms grow as
+// their elements arrive, so that a count in malformed input can't make
+// the Decoder allocate much more memory than the input takes.
+const maxPrealloc = 64
+
+// scratchPool holds buffers for data that is only needed while a term
+// is being decoded, such as headers and the text of atoms.
+var scratchPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 64)
+		return &b
+	},
+}
+
+// scratch reads n bytes into a buffer from scratchPool and passes them
+// to f. The buffer is reused once f returns, so f must not retain it.
+func (d *Decoder) scratch(n int, f func(b []byte)) error {
+	p := scratchPool.Get().(*[]byte)
+	if cap(*p) < n {
+		*p = make([]byte, n)
+	}
+	b := (*p)[:n]
+
+	_, err := io.ReadFull(d.r, b)
+	if err == nil {
+		f(b)
+	}
+
+	if cap(*p) <= maxScratch {
+		scratchPool.Put(p)
+	}
+	return err
+}
+
+// SetAtomCacheRefs sets the atom cache references used by the terms
+// that follow, as listed in a distribution header. An ATOM_CACHE_REF
+// with index i refers to the atom in the Context's atom cache at
+// indexes[i].
+func (d *Decoder) SetAtomCacheRefs(indexes []int) {
+	d.atomRefs = slices.Clone(indexes)
+}
+
+// BinaryMode selects the Go type that binaries are decoded as.
+type BinaryMode int
+
+const (
+	// BinaryAsBytes decodes binaries as []byte.
+	BinaryAsBytes BinaryMode = iota
+
+	// BinaryAsString decodes binaries as Go strings. Note that strings
+	// are encoded as STRING_EXT, so binaries decoded this way won't be
+	// encoded as binaries again.
+	BinaryAsString
+
+	// BinaryAsBinary decodes binaries as Binary.
+	BinaryAsBinary
+)
+
+// StringMode selects the Go type that STRING_EXT is decoded as.
+type StringMode int
+
+const (
+	// StringAsString decodes STRING_EXT as a Go string containing the
+	// encoded bytes, each of which is a Latin-1 character code.
+	StringAsString StringMode = iota
+
+	// StringAsList decodes STRING_EXT as a List of integers, which is
+	// what it means to Erlang. They are ints or int64s, as the
+	// IntegerMode selects.
+	StringAsList
+)
+
+// IntegerMode selects the Go type that integers are decoded as.
+type IntegerMode int
+
+const (
+	// IntegerAsInt decodes integers as int. Bignums that don't fit in
+	// an int are decoded as int64 if they fit in one.
+	IntegerAsInt IntegerMode = iota
+
+	// IntegerAsInt64 decodes integers as int64, regardless of the size
+	// of int.
+	IntegerAsInt64
+)
+
+// BigIntegerMode selects how bignums, which are integers encoded as
+// SMALL_BIG_EXT or LARGE_BIG_EXT, are decoded.
+type BigIntegerMode int
+
+const (
+	// BigAsSmallest decodes bignums using the type selected by the
+	// IntegerMode if they fit in it and as *big.Int otherwise.
+	BigAsSmallest BigIntegerMode = iota
+
+	// BigAsBigInt always decodes bignums as *big.Int.
+	BigAsBigInt
+
+	// BigAsError decodes bignums using the type selected by the
+	// IntegerMode, returning ErrIntegerOverflow if they don't fit.
+	BigAsError
+)
+
+// DecoderOptions configures how a Decoder represents terms.
+type DecoderOptions struct {
+	Binaries    BinaryMode
+	Strings     StringMode
+	Integers    IntegerMode
+	BigIntegers BigIntegerMode
+
+	// Strict requires every top-level term to be preceded by exactly
+	// one version byte, and rejects version bytes anywhere else.
+	Strict bool
+
+	// Charlists enables the detection of charlists. Proper lists and
+	// STRING_EXT terms that consist entirely of printable characters
+	// are decoded as Charlist.
+	Charlists bool
+}
+
+// A DecoderOption sets an option on a Decoder.
+type DecoderOption func(*DecoderOptions)
+
+// DecodeBinariesAs sets the Go type that binaries are decoded as.
+func DecodeBinariesAs(mode BinaryMode) DecoderOption {
+	return func(o *DecoderOptions) { o.Binaries = mode }
+}
+
+// DecodeStringsAs sets the Go type that STRING_EXT is decoded as.
+func DecodeStringsAs(mode StringMode) DecoderOption {
+	return func(o *DecoderOptions) { o.Strings = mode }
+}
+
+// DecodeIntegersAs sets the Go type that integers are decoded as.
+func DecodeIntegersAs(mode IntegerMode) DecoderOption {
+	return func(o *DecoderOptions) { o.Integers = mode }
 }
 
-// Decode reads the next term from the underlying reader.
+// DecodeBigIntegersAs sets how bignums are decoded.
+func DecodeBigIntegersAs(mode BigIntegerMode) DecoderOption {
+	return func(o *DecoderOptions) { o.BigIntegers = mode }
+}
+
+// DecodeStrict enables or disables strict mode.
+func DecodeStrict(enabled bool) DecoderOption {
+	return func(o *DecoderOptions) { o.Strict = enabled }
+}
+
+// DetectCharlists enables or disables the decoding of printable
+// charlists as Charlist.
+func DetectCharlists(enabled bool) DecoderOption {
+	return func(o *DecoderOptions) { o.Charlists = enabled }
+}
+
+// Decode reads the next term from the underlying reader. The term may
+// be preceded by the version byte, which is required in strict mode.
+// Terms compressed by term_to_binary/2 with the compressed option are
+// decompressed.
+//
+// If the reader ends before the term, Decode returns io.EOF. If it ends
+// in the middle of the term, it returns io.ErrUnexpectedEOF.
+//
+// Other errors are returned as a *DecodeError.
 func (d *Decoder) Decode() (term Term, err error) {
+	d.path = d.path[:0]
+
 	var etype byte
 	if etype, err = ruint8(d.r); err != nil {
 		return nil, err
 	}
+
+	if etype == EtVersion {
+		if etype, err = ruint8(d.r); err == io.EOF {
+			err = &DecodeError{Offset: d.InputOffset() - 1, Tag: EtVersion, Err: io.ErrUnexpectedEOF}
+		}
+	} else if d.opts.Strict {
+		err = &DecodeError{
+			Offset: d.InputOffset() - 1,
+			Tag:    etype,
+			Err:    fmt.Errorf("%w: expected %d, got %d", ErrVersion, EtVersion, etype),
+		}
+	}
+	switch {
+	case err != nil:
+	case etype == ettCompressed:
+		term, err = d.decodeCompressed()
+	default:
+		term, err = d.decode(etype)
+	}
+	return
+}
+
+// decodeCompressed reads a top-level term that was compressed with
+// zlib, as term_to_binary does with the compressed option.
+func (d *Decoder) decodeCompressed() (Term, error) {
+	start := d.InputOffset() - 1
+	fail := func(err error) (Term, error) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &DecodeError{Offset: start, Tag: ettCompressed, Err: err}
+	}
+
+	size, err := ruint32(d.r)
+	if err != nil {
+		return fail(err)
+	}
+	zr, err := zlib.NewReader(d.r)
+	if err != nil {
+		return fail(err)
+	}
+	// Reading past the expected size makes the zlib reader check the
+	// checksum at the end of the data.
+	b, err := io.ReadAll(io.LimitReader(zr, int64(size)+1))
+	if err != nil {
+		return fail(err)
+	}
+	if len(b) != int(size) {
+		return fail(fmt.Errorf("compressed term is %d bytes, expected %d", len(b), size))
+	}
+
+	sub := d.c.Decoder(bytes.NewReader(b))
+	sub.opts = d.opts
+	sub.atomRefs = d.atomRefs
+	term, err := sub.decodeNext()
+	if err != nil {
+		return nil, err
+	}
+	if n := sub.r.Buffered(); n != 0 {
+		return fail(fmt.Errorf("%d bytes left after compressed term", n))
+	}
+	return term, nil
+}
+
+// All returns an iterator over the terms read by Decode until the
+// reader ends. Iteration stops after the first error, which is yielded
+// with a nil term. If the reader ends between terms, iteration stops
+// without an error.
+func (d *Decoder) All() iter.Seq2[Term, error] {
+	return func(yield func(Term, error) bool) {
+		for {
+			term, err := d.Decode()
+			if err == io.EOF {
+				return
+			}
+			if !yield(term, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// decodeNext reads a term nested inside of another one.
+func (d *Decoder) decodeNext() (Term, error) {
+	etype, err := ruint8(d.r)
+	if err != nil {
+		return nil, err
+	}
+	return d.decode(etype)
+}
+
+// decodeAt reads a term at the given position inside of its parent.
+func (d *Decoder) decodeAt(kind byte, index int) (Term, error) {
+	d.path = append(d.path, pathElem{kind: kind, index: index})
+	term, err := d.decodeNext()
+	d.path = d.path[:len(d.path)-1]
+	return term, err
+}
+
+// decodeAtom reads a term that must be an atom.
+func (d *Decoder) decodeAtom() (Atom, error) {
+	term, err := d.decodeNext()
+	if err != nil {
+		return "", err
+	}
+	switch term := term.(type) {
+	case Atom:
+		return term, nil
+	case bool:
+		return Atom(strconv.FormatBool(term)), nil
+	}
+	return "", fmt.Errorf("expected an atom, got %T", term)
+}
+
+// decodeUint32 reads a term that must be an integer that fits in a
+// uint32.
+func (d *Decoder) decodeUint32() (uint32, error) {
+	term, err := d.decodeNext()
+	if err != nil {
+		return 0, err
+	}
+	var x int64
+	switch term := term.(type) {
+	case int:
+		x = int64(term)
+	case int64:
+		x = term
+	default:
+		return 0, fmt.Errorf("expected an integer, got %T", term)
+	}
+	if x < 0 || x > math.MaxUint32 {
+		return 0, fmt.Errorf("integer %d is out of range", x)
+	}
+	return uint32(x), nil
+}
+
+// decodePid reads a term that must be a pid.
+func (d *Decoder) decodePid() (Pid, error) {
+	term, err := d.decodeNext()
+	if err != nil {
+		return Pid{}, err
+	}
+	pid, ok := term.(Pid)
+	if !ok {
+		return Pid{}, fmt.Errorf("expected a pid, got %T", term)
+	}
+	return pid, nil
+}
+
+// decodeFreeVars reads the free variables of a fun.
+func (d *Decoder) decodeFreeVars(n uint32) ([]Term, error) {
+	vars := make([]Term, 0, min(n, maxPrealloc))
+	for range n {
+		v, err := d.decodeNext()
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, v)
+	}
+	return vars, nil
+}
+
+// decode reads the rest of a term with the given tag. Errors are
+// returned as a *DecodeError for the innermost term that they occur
+// in.
+func (d *Decoder) decode(etype byte) (term Term, err error) {
+	start := d.InputOffset() - 1
+	term, err = d.decodeTerm(etype)
+	if err == nil {
+		return term, nil
+	}
+
+	var de *DecodeError
+	if errors.As(err, &de) {
+		return nil, err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, &DecodeError{Offset: start, Path: formatPath(d.path), Tag: etype, Err: err}
+}
+
+func (d *Decoder) decodeTerm(etype byte) (term Term, err error) {
 	var b []byte
+	var n int
 
 	switch etype {
 	case EtVersion:
-		// Just skip the first byte if it was the version number.
-		return d.Decode()
+		// Version bytes are only valid before a top-level term, but
+		// are skipped anywhere unless in strict mode.
+		if d.opts.Strict {
+			return nil, fmt.Errorf("%w: unexpected version byte in a nested term", ErrVersion)
+		}
+		return d.decodeNext()
 
-	case ettAtom, ettAtomUTF8:
-		// $dLL… | $vLL…
-		if b, err = buint16(d.r); err == nil {
-			_, err = io.ReadFull(d.r, b)
-			term = newAtom(b)
+	case ettAtom:
+		// $dLL…
+		if n, err = rsize16(d.r); err == nil {
+			err = d.scratch(n, func(b []byte) { term = newAtom(latin1ToUTF8(b)) })
 		}
 
-	case ettSmallAtom, ettSmallAtomUTF8:
-		// $sL…, $wL…
-		if b, err = buint8(d.r); err == nil {
-			_, err = io.ReadFull(d.r, b)
-			term = newAtom(b)
+	case ettAtomUTF8:
+		// $vLL…
+		if n, err = rsize16(d.r); err == nil {
+			err = d.scratch(n, func(b []byte) { term = newAtom(b) })
+		}
+
+	case ettSmallAtom:
+		// $sL…
+		if n, err = rsize8(d.r); err == nil {
+			err = d.scratch(n, func(b []byte) { term = newAtom(latin1ToUTF8(b)) })
+		}
+
+	case ettSmallAtomUTF8:
+		// $wL…
+		if n, err = rsize8(d.r); err == nil {
+			err = d.scratch(n, func(b []byte) { term = newAtom(b) })
 		}
 
 	case ettBinary:
 		// $mLLLL…
-		if b, err = buint32(d.r); err == nil {
-			_, err = io.ReadFull(d.r, b)
-			term = b
+		var length uint32
+		if length, err = ruint32(d.r); err != nil {
+			break
+		}
+		if b, err = d.bytes(length); err == nil {
+			term = d.binary(b)
 		}
 
 	case ettString:
 		// $kLL…
-		if b, err = buint16(d.r); err == nil {
-			_, err = io.ReadFull(d.r, b)
-			term = string(b)
+		if n, err = rsize16(d.r); err == nil {
+			err = d.scratch(n, func(b []byte) { term = d.string(b) })
 		}
 
 	case ettFloat:
 		// $cFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF0
-		b = make([]byte, 31)
-		if _, err = io.ReadFull(d.r, b); err != nil {
+		var text string
+		if err = d.scratch(31, func(b []byte) { text = string(b) }); err != nil {
 			return
 		}
 		var r int
 		var f float64
-		if r, err = fmt.Sscanf(string(b), "%f", &f); r != 1 && err == nil {
+		if r, err = fmt.Sscanf(text, "%f", &f); r != 1 && err == nil {
 			err = ErrFloatScan
 		}
 		term = f
 
 	case ettNewFloat:
 		// $FFFFFFFFF
-		b = make([]byte, 8)
-		if _, err = io.ReadFull(d.r, b); err == nil {
-			term = math.Float64frombits(be.Uint64(b))
+		var bits uint64
+		if bits, err = ruint64(d.r); err == nil {
+			term = math.Float64frombits(bits)
 		}
 
 	case ettSmallInteger:
 		// $aI
 		var x uint8
 		x, err = ruint8(d.r)
-		term = int(x)
+		term = d.integer(int64(x))
 
 	case ettInteger:
 		// $bIIII
-		var x int32
-		err = binary.Read(d.r, be, &x)
-		term = int(x)
+		var x uint32
+		x, err = ruint32(d.r)
+		term = d.integer(int64(int32(x)))
 
 	case ettSmallBig:
 		// $nAS…
-		b = make([]byte, 2)
-		if _, err = io.ReadFull(d.r, b); err != nil {
+		var sign uint8
+		if n, err = rsize8(d.r); err != nil {
+			break
+		} else if sign, err = ruint8(d.r); err != nil {
 			break
 		}
-		sign := b[1]
-		b = make([]byte, b[0])
-		term, err = readBigInt(d.r, b, sign)
+		var v *big.Int
+		if err = d.scratch(n, func(b []byte) { v = newBigInt(b, sign) }); err == nil {
+			term, err = d.bigInt(v)
+		}
 
 	case ettLargeBig:
 		// $oAAAAS…
-		b = make([]byte, 5)
-		if _, err = io.ReadFull(d.r, b); err != nil {
+		var length uint32
+		var sign uint8
+		if length, err = ruint32(d.r); err != nil {
+			break
+		} else if sign, err = ruint8(d.r); err != nil {
+			break
+		} else if b, err = d.bytes(length); err != nil {
 			break
 		}
-		sign := b[4]
-		b = make([]byte, be.Uint32(b[:4]))
-		term, err = readBigInt(d.r, b, sign)
+		term, err = d.bigInt(newBigInt(b, sign))
 
 	case ettNil:
 		// $j
 		term = List{}
 
-	case ettPid:
-		var node any
+	case ettPid, ettNewPid:
+		// $gA…IIIISSSSC | $XA…IIIISSSSCCCC
 		var pid Pid
-		b = make([]byte, 9)
-		if node, err = d.Decode(); err != nil {
+		if pid.Node, err = d.decodeAtom(); err != nil {
 			return
-		} else if _, err = io.ReadFull(d.r, b); err != nil {
+		}
+		err = d.scratch(8+creationSize(etype), func(b []byte) {
+			pid.Id = be.Uint32(b[:4])
+			pid.Serial = be.Uint32(b[4:8])
+			pid.Creation = creation(b[8:])
+		})
+		if err != nil {
 			return
 		}
-		pid.Node = node.(Atom)
-		pid.Id = be.Uint32(b[:4])
-		pid.Serial = be.Uint32(b[4:8])
-		pid.Creation = b[8]
 		term = pid
 
-	case ettNewRef:
-		// $rLL…
+	case ettNewRef, ettNewerRef:
+		// $rLLA…C… | $ZLLA…CCCC…
 		var ref Ref
-		var node any
 		var nid uint16
 		if nid, err = ruint16(d.r); err != nil {
 			return
-		} else if node, err = d.Decode(); err != nil {
+		} else if ref.Node, err = d.decodeAtom(); err != nil {
 			return
-		} else if ref.Creation, err = ruint8(d.r); err != nil {
+		} else if err = d.scratch(creationSize(etype), func(b []byte) { ref.Creation = creation(b) }); err != nil {
 			return
 		}
-		ref.Node = node.(Atom)
 		ref.Id = make([]uint32, nid)
 		for i := 0; i < cap(ref.Id); i++ {
 			if ref.Id[i], err = ruint32(d.r); err != nil {
@@ -158,18 +650,17 @@ func (d *Decoder) Decode() (term Term, err error) {
 	case ettRef:
 		// $e…LLLLB
 		var ref Ref
-		var node any
-		if node, err = d.Decode(); err != nil {
+		if ref.Node, err = d.decodeAtom(); err != nil {
 			return
 		}
-		ref.Node = node.(Atom)
 		ref.Id = make([]uint32, 1)
+		var c uint8
 		if ref.Id[0], err = ruint32(d.r); err != nil {
 			return
-		} else if _, err = io.ReadFull(d.r, b); err != nil {
+		} else if c, err = ruint8(d.r); err != nil {
 			return
 		}
-		ref.Creation = b[0]
+		ref.Creation = uint32(c)
 		term = ref
 
 	case ettSmallTuple:
@@ -180,7 +671,7 @@ func (d *Decoder) Decode() (term Term, err error) {
 		}
 		tuple := make(Tuple, arity)
 		for i := 0; i < cap(tuple); i++ {
-			if tuple[i], err = d.Decode(); err != nil {
+			if tuple[i], err = d.decodeAt(pathTuple, i); err != nil {
 				break
 			}
 		}
@@ -192,34 +683,62 @@ func (d *Decoder) Decode() (term Term, err error) {
 		if arity, err = ruint32(d.r); err != nil {
 			break
 		}
-		tuple := make(Tuple, arity)
-		for i := 0; i < cap(tuple); i++ {
-			if tuple[i], err = d.Decode(); err != nil {
-				break
+		tuple := make(Tuple, 0, min(arity, maxPrealloc))
+		for i := range arity {
+			var e Term
+			if e, err = d.decodeAt(pathTuple, int(i)); err != nil {
+				return
 			}
+			tuple = append(tuple, e)
 		}
 		term = tuple
 
 	case ettList:
 		// $lLLLL…$j
-		var n uint32
-		if n, err = ruint32(d.r); err != nil {
+		var length uint32
+		if length, err = ruint32(d.r); err != nil {
 			return
 		}
-
-		list := make(List, n+1)
-		for i := 0; i < cap(list); i++ {
-			if list[i], err = d.Decode(); err != nil {
+		// The length is capped before adding room for the tail, so
+		// that a length of 0xFFFFFFFF can't overflow.
+		list := make(List, 0, min(length, maxPrealloc)+1)
+		for i := range length {
+			var e Term
+			if e, err = d.decodeAt(pathList, int(i)); err != nil {
 				return
 			}
+			list = append(list, e)
+		}
+		var tail Term
+		if tail, err = d.decodeAt(pathTail, 0); err != nil {
+			return
 		}
 
-		switch list[n].(type) {
+		switch tail := tail.(type) {
 		case List:
-			// proper list, remove nil element
-			list = list[:n]
+			// proper list
+			term = d.list(append(list, tail...))
+		default:
+			term = ImproperList{list, tail}
 		}
-		term = list
+
+	case ettMap:
+		// $tAAAA…
+		var arity uint32
+		if arity, err = ruint32(d.r); err != nil {
+			break
+		}
+		m := make(Map, 0, min(arity, maxPrealloc))
+		for i := range arity {
+			var e MapEntry
+			if e.Key, err = d.decodeAt(pathMapKey, int(i)); err != nil {
+				return
+			} else if e.Value, err = d.decodeAt(pathMapValue, int(i)); err != nil {
+				return
+			}
+			m = append(m, e)
+		}
+		term = m
 
 	case ettBitBinary:
 		// $MLLLLB…
@@ -230,88 +749,123 @@ func (d *Decoder) Decode() (term Term, err error) {
 		} else if bits, err = ruint8(d.r); err != nil {
 			break
 		}
-		b := make([]byte, length)
-		_, err = io.ReadFull(d.r, b)
-		b[len(b)-1] = b[len(b)-1] >> (8 - bits)
-		term = b
+		if b, err = d.bytes(length); err != nil {
+			break
+		}
+		if len(b) > 0 {
+			b[len(b)-1] = b[len(b)-1] >> (8 - bits)
+		}
+		term = d.binary(b)
 
 	case ettExport:
 		// $qM…F…A
-		var m, f any
-		var a uint8
-		if m, err = d.Decode(); err != nil {
+		var export Export
+		if export.Module, err = d.decodeAtom(); err != nil {
 			break
-		} else if f, err = d.Decode(); err != nil {
+		} else if export.Function, err = d.decodeAtom(); err != nil {
 			break
-		} else if a, err = ruint8(d.r); err != nil {
+		}
+		// The arity is a SMALL_INTEGER_EXT term, not a bare byte.
+		var arity uint32
+		if arity, err = d.decodeUint32(); err != nil {
+			break
+		} else if arity > math.MaxUint8 {
+			err = fmt.Errorf("arity %d is out of range", arity)
 			break
 		}
+		export.Arity = byte(arity)
 
-		term = Export{m.(Atom), f.(Atom), a}
+		term = export
 
 	case ettNewFun:
 		// $pSSSSAUUUUUUUUUUUUUUUUIIIIFFFFM…i…u…P…[V…]
 		var f Function
-		ruint32(d.r)
-		f.Arity, _ = ruint8(d.r)
-		io.ReadFull(d.r, f.Unique[:])
-		f.Index, _ = ruint32(d.r)
-		f.Free, _ = ruint32(d.r)
-		m, _ := d.Decode()
-		oldi, _ := d.Decode()
-		oldu, _ := d.Decode()
-		pid, _ := d.Decode()
-
-		f.FreeVars = make([]Term, f.Free)
-		for i := 0; i < cap(f.FreeVars); i++ {
-			if f.FreeVars[i], err = d.Decode(); err != nil {
-				break
-			}
+		if _, err = ruint32(d.r); err != nil {
+			break
+		} else if f.Arity, err = ruint8(d.r); err != nil {
+			break
+		} else if _, err = io.ReadFull(d.r, f.Unique[:]); err != nil {
+			break
+		} else if f.Index, err = ruint32(d.r); err != nil {
+			break
+		} else if f.Free, err = ruint32(d.r); err != nil {
+			break
+		} else if f.Module, err = d.decodeAtom(); err != nil {
+			break
+		} else if f.OldIndex, err = d.decodeUint32(); err != nil {
+			break
+		} else if f.OldUnique, err = d.decodeUint32(); err != nil {
+			break
+		} else if f.Pid, err = d.decodePid(); err != nil {
+			break
+		} else if f.FreeVars, err = d.decodeFreeVars(f.Free); err != nil {
+			break
 		}
-
-		f.Module = m.(Atom)
-		f.OldIndex = uint32(oldi.(int))
-		f.OldUnique = uint32(oldu.(int))
-		f.Pid = pid.(Pid)
 		term = f
 
 	case ettFun:
 		// $uFFFFP…M…i…u…[V…]
 		var f Function
-		f.Free, _ = ruint32(d.r)
-		pid, _ := d.Decode()
-		m, _ := d.Decode()
-		oldi, _ := d.Decode()
-		oldu, _ := d.Decode()
-
-		f.FreeVars = make([]Term, f.Free)
-		for i := 0; i < cap(f.FreeVars); i++ {
-			if f.FreeVars[i], err = d.Decode(); err != nil {
-				break
-			}
+		if f.Free, err = ruint32(d.r); err != nil {
+			break
+		} else if f.Pid, err = d.decodePid(); err != nil {
+			break
+		} else if f.Module, err = d.decodeAtom(); err != nil {
+			break
+		} else if f.OldIndex, err = d.decodeUint32(); err != nil {
+			break
+		} else if f.OldUnique, err = d.decodeUint32(); err != nil {
+			break
+		} else if f.FreeVars, err = d.decodeFreeVars(f.Free); err != nil {
+			break
 		}
-
-		f.Module = m.(Atom)
-		f.OldIndex = uint32(oldi.(int))
-		f.OldUnique = uint32(oldu.(int))
-		f.Pid = pid.(Pid)
 		term = f
 
-	case ettPort:
-		// $fA…IIIIC
+	case ettPort, ettNewPort, ettV4Port:
+		// $fA…IIIIC | $YA…IIIICCCC | $xA…IIIIIIIICCCC
 		var p Port
-		a, _ := d.Decode()
-		p.Node = a.(Atom)
-		p.Id, _ = ruint32(d.r)
-		p.Creation, err = ruint8(d.r)
+		idSize := 4
+		if etype == ettV4Port {
+			idSize = 8
+		}
+		if p.Node, err = d.decodeAtom(); err != nil {
+			return
+		}
+		err = d.scratch(idSize+creationSize(etype), func(b []byte) {
+			for _, c := range b[:idSize] {
+				p.Id = p.Id<<8 | uint64(c)
+			}
+			p.Creation = creation(b[idSize:])
+		})
+		if err != nil {
+			return
+		}
 		term = p
 
+	case ettLocal:
+		// $yHHHHHHHH…
+		// The hash is only meaningful to the node that created the
+		// term, so it is skipped and the term it wraps is returned.
+		if _, err = d.r.Discard(8); err == nil {
+			term, err = d.decodeNext()
+		}
+
 	case ettCacheRef:
-		b = make([]byte, 1)
-		if _, err = io.ReadFull(d.r, b); err != nil {
+		// $RI
+		var i int
+		if i, err = rsize8(d.r); err != nil {
 			break
 		}
-		term = Atom(*d.c.currentCache[b[0]])
+		if i >= len(d.atomRefs) {
+			err = fmt.Errorf("atom cache reference %d out of range", i)
+			break
+		}
+		atom, ok := d.c.CachedAtom(d.atomRefs[i])
+		if !ok {
+			err = fmt.Errorf("atom cache entry %d is empty", d.atomRefs[i])
+			break
+		}
+		term = newAtom([]byte(atom))
 
 	default:
 		err = &ErrUnknownTerm{etype}
@@ -320,6 +874,130 @@ func (d *Decoder) Decode() (term Term, err error) {
 	return
 }
 
+func (d *Decoder) integer(x int64) Term {
+	if d.opts.Integers == IntegerAsInt64 {
+		return x
+	}
+	return int(x)
+}
+
+func (d *Decoder) bigInt(v *big.Int) (Term, error) {
+	switch d.opts.BigIntegers {
+	case BigAsBigInt:
+		return v, nil
+
+	case BigAsError:
+		if !v.IsInt64() {
+			return nil, fmt.Errorf("%w: %v", ErrIntegerOverflow, v)
+		}
+		if d.opts.Integers == IntegerAsInt64 {
+			return v.Int64(), nil
+		}
+		if x := int(v.Int64()); int64(x) == v.Int64() {
+			return x, nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrIntegerOverflow, v)
+	}
+
+	if d.opts.Integers == IntegerAsInt64 && v.IsInt64() {
+		return v.Int64(), nil
+	}
+	return bigIntTerm(v), nil
+}
+
+func (d *Decoder) binary(b []byte) Term {
+	switch d.opts.Binaries {
+	case BinaryAsString:
+		return string(b)
+	case BinaryAsBinary:
+		return Binary(b)
+	}
+	return b
+}
+
+func (d *Decoder) string(b []byte) Term {
+	if d.opts.Charlists && printableLatin1(b) {
+		return Charlist(latin1ToUTF8(b))
+	}
+
+	if d.opts.Strings == StringAsList {
+		list := make(List, len(b))
+		for i, c := range b {
+			list[i] = d.integer(int64(c))
+		}
+		return list
+	}
+	return string(b)
+}
+
+// list returns a proper list, converting it to a Charlist if it is a
+// printable charlist and charlist detection is enabled.
+func (d *Decoder) list(l List) Term {
+	if !d.opts.Charlists || len(l) == 0 {
+		return l
+	}
+
+	runes := make([]rune, len(l))
+	for i, e := range l {
+		c, ok := charCode(e)
+		if !ok || !printableRune(c) {
+			return l
+		}
+		runes[i] = c
+	}
+	return Charlist(runes)
+}
+
+// charCode returns e as a character if it is an integer in the range of
+// Unicode code points, whichever IntegerMode and BigIntegerMode it was
+// decoded with.
+func charCode(e Term) (rune, bool) {
+	var x int64
+	switch e := e.(type) {
+	case int:
+		x = int64(e)
+	case int64:
+		x = e
+	case *big.Int:
+		if !e.IsInt64() {
+			return 0, false
+		}
+		x = e.Int64()
+	default:
+		return 0, false
+	}
+	if x < 0 || x > utf8.MaxRune {
+		return 0, false
+	}
+	return rune(x), true
+}
+
+func printableLatin1(b []byte) bool {
+	for _, c := range b {
+		if !printableRune(rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// latin1ToUTF8 converts Latin-1 text to UTF-8.
+func latin1ToUTF8(b []byte) []byte {
+	ascii := true
+	for _, c := range b {
+		ascii = ascii && c < utf8.RuneSelf
+	}
+	if ascii {
+		return b
+	}
+
+	text := make([]byte, 0, 2*len(b))
+	for _, c := range b {
+		text = utf8.AppendRune(text, rune(c))
+	}
+	return text
+}
+
 func newAtom(b []byte) any {
 	if bytes.Compare(b, bTrue) == 0 {
 		return true
@@ -329,11 +1007,9 @@ func newAtom(b []byte) any {
 	return Atom(b)
 }
 
-func readBigInt(r *bufio.Reader, b []byte, sign byte) (any, error) {
-	if _, err := io.ReadFull(r, b); err != nil {
-		return nil, err
-	}
-
+// newBigInt returns the integer with the given little-endian magnitude
+// and sign. It reverses b in place.
+func newBigInt(b []byte, sign byte) *big.Int {
 	size := len(b)
 	hsize := size >> 1
 	for i := 0; i < hsize; i++ {
@@ -345,15 +1021,39 @@ func readBigInt(r *bufio.Reader, b []byte, sign byte) (any, error) {
 		v = v.Neg(v)
 	}
 
+	return v
+}
+
+// bigIntTerm returns v as an int or int64 if it fits in one, and as v
+// itself otherwise.
+func bigIntTerm(v *big.Int) any {
 	// try int and int64
 	v64 := v.Int64()
 	if x := int(v64); v.Cmp(big.NewInt(int64(x))) == 0 {
-		return x, nil
+		return x
 	} else if v.Cmp(big.NewInt(v64)) == 0 {
-		return v64, nil
+		return v64
+	}
+
+	return v
+}
+
+// creationSize returns the size of the creation field of a pid, port
+// or reference with the given tag.
+func creationSize(tag byte) int {
+	switch tag {
+	case ettNewPid, ettNewPort, ettV4Port, ettNewerRef:
+		return 4
 	}
+	return 1
+}
 
-	return v, nil
+// creation decodes a creation field of either size.
+func creation(b []byte) uint32 {
+	if len(b) == 1 {
+		return uint32(b[0])
+	}
+	return be.Uint32(b)
 }
 
 func ruint8(r *bufio.Reader) (uint8, error) {
@@ -361,30 +1061,69 @@ func ruint8(r *bufio.Reader) (uint8, error) {
 }
 
 func ruint16(r *bufio.Reader) (uint16, error) {
-	var b [2]byte
-	_, err := io.ReadFull(r, b[:])
-	return be.Uint16(b[:]), err
+	b, err := readN(r, 2)
+	if err != nil {
+		return 0, err
+	}
+	return be.Uint16(b), nil
 }
 
 func ruint32(r *bufio.Reader) (uint32, error) {
-	var b [4]byte
-	_, err := io.ReadFull(r, b[:])
-	return be.Uint32(b[:]), err
+	b, err := readN(r, 4)
+	if err != nil {
+		return 0, err
+	}
+	return be.Uint32(b), nil
 }
 
-func buint8(r *bufio.Reader) ([]byte, error) {
+func ruint64(r *bufio.Reader) (uint64, error) {
+	b, err := readN(r, 8)
+	if err != nil {
+		return 0, err
+	}
+	return be.Uint64(b), nil
+}
+
+// readN reads n bytes, which must fit in r's buffer, returning a slice
+// of the buffer that is only valid until the next read. Unlike reading
+// into an array, it doesn't allocate.
+func readN(r *bufio.Reader, n int) ([]byte, error) {
+	b, err := r.Peek(n)
+	if err != nil {
+		if len(b) > 0 && err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	r.Discard(n)
+	return b, nil
+}
+
+func rsize8(r *bufio.Reader) (int, error) {
 	size, err := ruint8(r)
-	return make([]byte, size), err
+	return int(size), err
 }
 
-func buint16(r *bufio.Reader) ([]byte, error) {
+func rsize16(r *bufio.Reader) (int, error) {
 	size, err := ruint16(r)
-	return make([]byte, size), err
+	return int(size), err
 }
 
-func buint32(r *bufio.Reader) ([]byte, error) {
-	size, err := ruint32(r)
-	return make([]byte, size), err
+// bytes reads n bytes into a new slice. Beyond maxScratch bytes, the
+// slice grows as the bytes arrive, so that a length in malformed input
+// can't make it allocate much more memory than the input takes.
+func (d *Decoder) bytes(n uint32) ([]byte, error) {
+	b := make([]byte, 0, min(n, maxScratch))
+	for uint32(len(b)) < n {
+		chunk := int(min(n-uint32(len(b)), uint32(max(len(b), maxScratch))))
+		b = slices.Grow(b, chunk)
+		m, err := io.ReadFull(d.r, b[len(b):len(b)+chunk])
+		if err != nil {
+			return nil, err
+		}
+		b = b[:len(b)+m]
+	}
+	return b, nil
 }
 
 type ErrUnknownTerm struct {
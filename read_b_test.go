@@ -26,11 +26,14 @@ func BenchmarkReadAtom(b *testing.B) {
 		atoms[i] = w
 	}
 
+	r := new(bytes.Reader)
+	d := c.Decoder(r)
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
-		in := atoms[i%max]
-		d := c.Decoder(in)
+		r.Reset(atoms[i%max].Bytes())
+		d.Reset(r)
 		_, err := d.Decode()
 
 		if err != io.EOF && err != nil {
@@ -94,11 +97,14 @@ func BenchmarkReadBinary(b *testing.B) {
 		binaries[i] = w
 	}
 
+	r := new(bytes.Reader)
+	d := c.Decoder(r)
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
-		in := binaries[i%max]
-		d := c.Decoder(in)
+		r.Reset(binaries[i%max].Bytes())
+		d.Reset(r)
 		_, err := d.Decode()
 
 		if err != io.EOF && err != nil {
@@ -122,11 +128,14 @@ func BenchmarkReadFloat(b *testing.B) {
 		floats[i] = w
 	}
 
+	r := new(bytes.Reader)
+	d := c.Decoder(r)
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
-		in := floats[i%max]
-		d := c.Decoder(in)
+		r.Reset(floats[i%max].Bytes())
+		d.Reset(r)
 		_, err := d.Decode()
 
 		if err != io.EOF && err != nil {
@@ -156,11 +165,14 @@ func BenchmarkReadPid(b *testing.B) {
 		pids[i] = w
 	}
 
+	r := new(bytes.Reader)
+	d := c.Decoder(r)
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
-		in := pids[i%max]
-		d := c.Decoder(in)
+		r.Reset(pids[i%max].Bytes())
+		d.Reset(r)
 		_, err := d.Decode()
 
 		if err != io.EOF && err != nil {
@@ -187,11 +199,42 @@ func BenchmarkReadString(b *testing.B) {
 		strings[i] = w
 	}
 
+	r := new(bytes.Reader)
+	d := c.Decoder(r)
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
-		in := strings[i%max]
-		d := c.Decoder(in)
+		r.Reset(strings[i%max].Bytes())
+		d.Reset(r)
+		_, err := d.Decode()
+
+		if err != io.EOF && err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadNewDecoder decodes the same terms as BenchmarkReadAtom,
+// but allocates a new Decoder for each one instead of reusing one.
+func BenchmarkReadNewDecoder(b *testing.B) {
+	b.StopTimer()
+	c := new(Context)
+
+	max := 64
+	length := 64
+	atoms := make([][]byte, max)
+
+	for i := 0; i < max; i++ {
+		s := bytes.Repeat([]byte{'a'}, length)
+		atoms[i] = append([]byte{ettSmallAtom, byte(length)}, bytes.Map(randRune, s)...)
+	}
+
+	b.ReportAllocs()
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		d := c.Decoder(bytes.NewReader(atoms[i%max]))
 		_, err := d.Decode()
 
 		if err != io.EOF && err != nil {
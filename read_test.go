@@ -2,7 +2,13 @@ package etf
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"testing"
 )
 
@@ -79,6 +85,18 @@ func TestReadBinary(t *testing.T) {
 	} else if exp := []byte{1, 2, 3, 4, 5}; bytes.Compare(exp, v.([]byte)) != 0 {
 		t.Errorf("expected %v, got %v", exp, v)
 	}
+
+	// Binaries bigger than a scratch buffer are read in chunks.
+	long := make([]byte, 3*maxScratch+1)
+	for i := range long {
+		long[i] = byte(i)
+	}
+	in = bytes.NewBuffer(append([]byte{109, 0, 3, 0, 1}, long...))
+	if v, err := c.Decoder(in).Decode(); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(v.([]byte), long) {
+		t.Errorf("expected %d bytes, got %d", len(long), len(v.([]byte)))
+	}
 }
 
 func TestReadBitBinary(t *testing.T) {
@@ -466,3 +484,330 @@ func TestReadTerm(t *testing.T) {
 		t.Errorf("buffer len %d", l)
 	}
 }
+
+func TestReadMap(t *testing.T) {
+	c := new(Context)
+
+	// #{a => 1}
+	in := bytes.NewBuffer([]byte{116, 0, 0, 0, 1, 115, 1, 97, 97, 1})
+	d := c.Decoder(in)
+	if v, err := d.Decode(); err != nil {
+		t.Error(err)
+	} else if l := in.Len(); l != 0 {
+		t.Errorf("buffer len %d", l)
+	} else if exp := (Map{{Atom("a"), 1}}); !Equal(exp, v) {
+		t.Errorf("expected %v, got %v", exp, v)
+	}
+
+	// error (missing value)
+	d = c.Decoder(bytes.NewBuffer([]byte{116, 0, 0, 0, 1, 115, 1, 97}))
+	if _, err := d.Decode(); err == nil {
+		t.Error("err == nil")
+	}
+}
+
+func TestReadImproperList(t *testing.T) {
+	c := new(Context)
+
+	// [a | b]
+	in := bytes.NewBuffer([]byte{108, 0, 0, 0, 1, 115, 1, 97, 115, 1, 98})
+	d := c.Decoder(in)
+	if v, err := d.Decode(); err != nil {
+		t.Error(err)
+	} else if l := in.Len(); l != 0 {
+		t.Errorf("buffer len %d", l)
+	} else if exp := (ImproperList{List{Atom("a")}, Atom("b")}); !Equal(exp, v) {
+		t.Errorf("expected %v, got %v", exp, v)
+	}
+}
+
+func TestReadBinaryMode(t *testing.T) {
+	c := new(Context)
+	test := func(mode BinaryMode, exp Term) {
+		// <<"abc">>
+		in := bytes.NewBuffer([]byte{109, 0, 0, 0, 3, 97, 98, 99})
+		d := c.Decoder(in, DecodeBinariesAs(mode))
+		if v, err := d.Decode(); err != nil {
+			t.Error(err)
+		} else if l := in.Len(); l != 0 {
+			t.Errorf("buffer len %d", l)
+		} else if !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %#v, got %#v", exp, v)
+		}
+	}
+
+	test(BinaryAsBytes, []byte("abc"))
+	test(BinaryAsString, "abc")
+	test(BinaryAsBinary, Binary("abc"))
+}
+
+func TestReadStringMode(t *testing.T) {
+	c := new(Context)
+	test := func(in []byte, exp Term, opts ...DecoderOption) {
+		d := c.Decoder(bytes.NewBuffer(in), opts...)
+		if v, err := d.Decode(); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %#v, got %#v", exp, v)
+		}
+	}
+
+	// "abc"
+	str := []byte{107, 0, 3, 97, 98, 99}
+	test(str, "abc")
+	test(str, List{97, 98, 99}, DecodeStringsAs(StringAsList))
+	test(str, Charlist("abc"), DecodeStringsAs(StringAsList), DetectCharlists(true))
+
+	// [1, 2]
+	test([]byte{107, 0, 2, 1, 2}, List{1, 2}, DecodeStringsAs(StringAsList), DetectCharlists(true))
+	test([]byte{107, 0, 2, 1, 2}, List{int64(1), int64(2)}, DecodeStringsAs(StringAsList), DecodeIntegersAs(IntegerAsInt64))
+
+	// "é" is a single Latin-1 byte in STRING_EXT.
+	test([]byte{107, 0, 1, 233}, "\xe9")
+	test([]byte{107, 0, 1, 233}, Charlist("é"), DetectCharlists(true))
+
+	// "hé€" as a list, because € isn't Latin-1
+	list := []byte{108, 0, 0, 0, 3, 97, 104, 97, 233, 98, 0, 0, 0x20, 0xac, 106}
+	test(list, List{104, 233, 0x20ac})
+	test(list, Charlist("hé€"), DetectCharlists(true))
+
+	// [] stays a list
+	test([]byte{106}, List{}, DetectCharlists(true))
+}
+
+func TestCharlistRoundTrip(t *testing.T) {
+	c := new(Context)
+	test := func(in []byte, list List, erl string) {
+		v, err := c.Decoder(bytes.NewReader(in), DetectCharlists(true)).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := v.(Charlist); !ok {
+			t.Fatalf("expected a Charlist, got %T", v)
+		}
+		if !ExactEqual(v, list) {
+			t.Errorf("%v isn't equal to %v", v, list)
+		}
+		if s := Format(v); s != erl {
+			t.Errorf("expected %s, got %s", erl, s)
+		}
+		if s, err := As[string](v); err != nil || s != erl[1:len(erl)-1] {
+			t.Errorf("expected %s as a string, got %q (%v)", erl, s, err)
+		}
+
+		w := new(bytes.Buffer)
+		if err := c.Encoder(w).Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(w.Bytes()[1:], in) {
+			t.Errorf("expected % x, got % x", in, w.Bytes()[1:])
+		}
+	}
+
+	test([]byte{107, 0, 2, 104, 233}, List{104, 233}, `"hé"`)
+	test([]byte{108, 0, 0, 0, 3, 97, 104, 97, 233, 98, 0, 0, 0x20, 0xac, 106}, List{104, 233, 0x20ac}, `"hé€"`)
+}
+
+func TestReadIntegerMode(t *testing.T) {
+	c := new(Context)
+	test := func(in []byte, exp Term, opts ...DecoderOption) {
+		d := c.Decoder(bytes.NewBuffer(in), opts...)
+		if v, err := d.Decode(); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(v, exp) {
+			t.Errorf("expected %#v, got %#v", exp, v)
+		}
+	}
+
+	small := []byte{97, 255}
+	integer := []byte{98, 255, 255, 255, 255}
+	smallBig := []byte{110, 1, 0, 5}
+	largeBig := []byte{111, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+
+	test(small, 255)
+	test(small, int64(255), DecodeIntegersAs(IntegerAsInt64))
+	test(integer, -1)
+	test(integer, int64(-1), DecodeIntegersAs(IntegerAsInt64))
+	test(smallBig, 5)
+	test(smallBig, int64(5), DecodeIntegersAs(IntegerAsInt64))
+	test(smallBig, big.NewInt(5), DecodeBigIntegersAs(BigAsBigInt))
+	test(smallBig, 5, DecodeBigIntegersAs(BigAsError))
+	test(largeBig, new(big.Int).Lsh(big.NewInt(1), 64))
+	test(largeBig, new(big.Int).Lsh(big.NewInt(1), 64), DecodeIntegersAs(IntegerAsInt64))
+
+	d := c.Decoder(bytes.NewBuffer(largeBig), DecodeBigIntegersAs(BigAsError))
+	if _, err := d.Decode(); !errors.Is(err, ErrIntegerOverflow) {
+		t.Errorf("expected overflow, got %v", err)
+	}
+
+	// Charlists are detected whatever their integers decode as, so
+	// that "hi" decodes the same from LIST_EXT and STRING_EXT.
+	list := []byte{108, 0, 0, 0, 2, 97, 104, 97, 105, 106}
+	bigList := []byte{108, 0, 0, 0, 2, 110, 1, 0, 104, 110, 1, 0, 105, 106}
+	str := []byte{107, 0, 2, 104, 105}
+	int64s := DecodeIntegersAs(IntegerAsInt64)
+	charlists := DetectCharlists(true)
+	test(list, Charlist("hi"), int64s, charlists)
+	test(str, Charlist("hi"), int64s, charlists)
+	test(bigList, Charlist("hi"), DecodeBigIntegersAs(BigAsBigInt), charlists)
+	test(bigList, List{big.NewInt(104), big.NewInt(105)}, DecodeBigIntegersAs(BigAsBigInt))
+}
+
+func TestReadLocal(t *testing.T) {
+	c := new(Context)
+	in := []byte{ettLocal, 1, 2, 3, 4, 5, 6, 7, 8, ettNewPid, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3}
+	v, err := c.Decoder(bytes.NewReader(in)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (Pid{Atom("a"), 1, 2, 3}); v != exp {
+		t.Errorf("expected %v, got %v", exp, v)
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	c := new(Context)
+	d := c.Decoder(bytes.NewReader([]byte{ettSmallInteger, 1, ettSmallInteger, 2}), DecodeIntegersAs(IntegerAsInt64))
+	if v, err := d.Decode(); err != nil || v != int64(1) {
+		t.Fatalf("expected 1, got %v (%v)", v, err)
+	}
+
+	// The rest of the first reader is discarded, but the options are
+	// kept.
+	d.Reset(bytes.NewReader([]byte{ettSmallInteger, 3}))
+	if v, err := d.Decode(); err != nil || v != int64(3) {
+		t.Fatalf("expected 3, got %v (%v)", v, err)
+	}
+	if _, err := d.Decode(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestReadStrict(t *testing.T) {
+	c := new(Context)
+	test := func(in []byte, strict bool, expErr error) {
+		_, err := c.Decoder(bytes.NewReader(in), DecodeStrict(strict)).Decode()
+		if expErr == nil && err != nil {
+			t.Errorf("%v (strict %v): %v", in, strict, err)
+		} else if expErr != nil && !errors.Is(err, expErr) {
+			t.Errorf("%v (strict %v): expected %v, got %v", in, strict, expErr, err)
+		}
+	}
+
+	test([]byte{EtVersion, ettSmallInteger, 1}, true, nil)
+	test([]byte{ettSmallInteger, 1}, true, ErrVersion)
+	test([]byte{ettSmallInteger, 1}, false, nil)
+	test([]byte{EtVersion, ettSmallTuple, 1, EtVersion, ettSmallInteger, 1}, true, ErrVersion)
+	test([]byte{EtVersion, ettSmallTuple, 1, EtVersion, ettSmallInteger, 1}, false, nil)
+	test([]byte{EtVersion, EtVersion, ettSmallInteger, 1}, true, ErrVersion)
+}
+
+func TestReadTruncated(t *testing.T) {
+	c := new(Context)
+	for _, in := range [][]byte{
+		{EtVersion},
+		{ettSmallTuple, 2, ettSmallInteger, 1},
+		{ettList, 0, 0, 0, 1, ettSmallInteger, 1},
+		{ettSmallAtomUTF8, 2, 'o'},
+		{ettNewFloat, 0, 0},
+	} {
+		if _, err := c.Decoder(bytes.NewReader(in)).Decode(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%v: expected io.ErrUnexpectedEOF, got %v", in, err)
+		}
+	}
+
+	if _, err := c.Decoder(bytes.NewReader(nil)).Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestDecoderAll(t *testing.T) {
+	c := new(Context)
+	in := []byte{
+		EtVersion, ettSmallInteger, 1,
+		EtVersion, ettSmallAtomUTF8, 2, 'o', 'k',
+		EtVersion, ettNil,
+	}
+
+	var terms []Term
+	for term, err := range c.Decoder(bytes.NewReader(in), DecodeStrict(true)).All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		terms = append(terms, term)
+	}
+	if exp := []Term{1, Atom("ok"), List{}}; !reflect.DeepEqual(terms, exp) {
+		t.Errorf("expected %v, got %v", exp, terms)
+	}
+
+	// Truncation is reported, rather than ending iteration cleanly.
+	var errs []error
+	for _, err := range c.Decoder(bytes.NewReader(in[:len(in)-4])).All() {
+		errs = append(errs, err)
+	}
+	if len(errs) != 2 || errs[0] != nil || !errors.Is(errs[1], io.ErrUnexpectedEOF) {
+		t.Errorf("expected [nil, io.ErrUnexpectedEOF], got %v", errs)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	c := new(Context)
+	test := func(in []byte, offset int64, path string, tag byte, cause func(error) bool) {
+		_, err := c.Decoder(bytes.NewReader(in)).Decode()
+		var de *DecodeError
+		if !errors.As(err, &de) {
+			t.Errorf("%v: expected *DecodeError, got %v", in, err)
+			return
+		}
+		if de.Offset != offset || de.Path != path || de.Tag != tag {
+			t.Errorf("%v: expected offset %d, path %q and tag %s, got %v", in, offset, path, tagName(tag), de)
+		}
+		if !cause(err) {
+			t.Errorf("%v: unexpected cause %v", in, de.Err)
+		}
+	}
+	unexpectedEOF := func(err error) bool { return errors.Is(err, io.ErrUnexpectedEOF) }
+
+	test([]byte{EtVersion, ettSmallTuple, 2, ettSmallInteger, 1, ettList, 0, 0, 0, 2, ettNil, 'z', ettNil},
+		11, "{1}[1]", 'z', func(err error) bool {
+			var unknown *ErrUnknownTerm
+			return errors.As(err, &unknown)
+		})
+	test([]byte{ettMap, 0, 0, 0, 1, ettNil, ettSmallAtomUTF8, 5, 'a'},
+		6, "#{0}.value", ettSmallAtomUTF8, unexpectedEOF)
+	test([]byte{ettList, 0, 0, 0, 1, ettNil, ettSmallTuple, 1},
+		6, "|", ettSmallTuple, unexpectedEOF)
+	test([]byte{ettNewPid, ettNil, 0, 0, 0, 0},
+		0, "", ettNewPid, func(err error) bool { return err != nil })
+	test([]byte{EtVersion, ettSmallInteger}, 1, "", ettSmallInteger, unexpectedEOF)
+
+	// Lengths that the input is too short for are reported as
+	// truncation, without allocating for them first.
+	for _, tag := range []byte{ettList, ettLargeTuple, ettMap, ettBinary} {
+		test([]byte{EtVersion, tag, 0xff, 0xff, 0xff, 0xff}, 1, "", tag, unexpectedEOF)
+		test([]byte{EtVersion, tag, 0x7f, 0xff, 0xff, 0xff}, 1, "", tag, unexpectedEOF)
+	}
+	test([]byte{EtVersion, ettLargeBig, 0xff, 0xff, 0xff, 0xff, 0}, 1, "", ettLargeBig, unexpectedEOF)
+	test([]byte{EtVersion, ettBitBinary, 0xff, 0xff, 0xff, 0xff, 1}, 1, "", ettBitBinary, unexpectedEOF)
+}
+
+func TestReadCompressed(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "corpus", "compressed_string.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bad := [][]byte{
+		data[:len(data)-2],
+		append(slices.Clone(data[:len(data)-1]), data[len(data)-1]^1),
+		append([]byte{EtVersion, ettCompressed, 0, 0, 3, 0xea}, data[6:]...),
+		append([]byte{EtVersion, ettCompressed, 0, 0, 3, 0xec}, data[6:]...),
+	}
+	for i, in := range bad {
+		_, err := new(Context).Decoder(bytes.NewReader(in)).Decode()
+		var derr *DecodeError
+		if !errors.As(err, &derr) || derr.Tag != ettCompressed {
+			t.Errorf("%d: expected DecodeError for a compressed term, got %v", i, err)
+		}
+	}
+}
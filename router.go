@@ -0,0 +1,128 @@
+package etf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// ErrNoRoute is returned, wrapped, by Router.Dispatch when no handler
+// is registered for a term and the Router has no Fallback.
+var ErrNoRoute = errors.New("router: no route")
+
+// A Router dispatches tagged tuples, such as {call, From, Request}, to
+// handlers registered for their tag atom and size. Handlers should be
+// registered before the Router starts dispatching terms.
+//
+// The zero value is a Router with no routes.
+type Router struct {
+	// Fallback, if it isn't nil, is called with terms that no handler
+	// is registered for, including terms that aren't tagged tuples.
+	Fallback func(t Term) error
+
+	// Error, if it isn't nil, is called when a handler or Fallback
+	// returns an error or when a term can't be converted to the
+	// argument type of its handler. What it returns is returned by
+	// Dispatch instead, so it can return nil to ignore the error and
+	// carry on serving.
+	Error func(t Term, err error) error
+
+	routes map[route]func(Tuple) error
+}
+
+type route struct {
+	tag  Atom
+	size int
+}
+
+// HandleFunc registers h to handle tuples of the given size whose
+// first element is tag. The size includes the tag, so {cast, Request}
+// has size 2. The tags true and false also match tuples that start
+// with the bools that those atoms decode as. It panics if a handler is
+// already registered for the same tag and size.
+func (r *Router) HandleFunc(tag Atom, size int, h func(t Tuple) error) {
+	if size < 1 {
+		panic(fmt.Errorf("router: invalid tuple size %d", size))
+	}
+	k := route{tag, size}
+	if _, ok := r.routes[k]; ok {
+		panic(fmt.Errorf("router: multiple handlers for %v/%d", tag, size))
+	}
+	if r.routes == nil {
+		r.routes = make(map[route]func(Tuple) error)
+	}
+	r.routes[k] = h
+}
+
+// Handle registers h to handle tuples whose first element is tag and
+// whose other elements convert to the exported fields of the struct T
+// using As. The size of the tuples is one more than the number of
+// fields in T. For example, given
+//
+//	type Call struct {
+//		From    etf.Tuple
+//		Request etf.Term
+//	}
+//
+// Handle(r, "call", h) calls h with each {call, From, Request} tuple.
+// Handle panics if T isn't a struct type.
+func Handle[T any](r *Router, tag Atom, h func(args T) error) {
+	rt := reflect.TypeFor[T]()
+	if rt.Kind() != reflect.Struct || termStructs[rt] {
+		panic(fmt.Errorf("router: handler argument type %v isn't a struct", rt))
+	}
+
+	r.HandleFunc(tag, len(recordFields(rt))+1, func(t Tuple) error {
+		args, err := As[T](t[1:])
+		if err != nil {
+			return fmt.Errorf("%v/%d: %w", tag, len(t), err)
+		}
+		return h(args)
+	})
+}
+
+// Dispatch calls the handler registered for t. If there isn't one, it
+// calls Fallback or, if that is nil, returns an error wrapping
+// ErrNoRoute.
+func (r *Router) Dispatch(t Term) error {
+	h := r.Fallback
+	if tuple, ok := t.(Tuple); ok && len(tuple) > 0 {
+		// The tags true and false decode as bools.
+		switch tag := tuple[0].(type) {
+		case Atom, bool:
+			if rh, ok := r.routes[route{Atom(atomText(tag)), len(tuple)}]; ok {
+				h = func(Term) error { return rh(tuple) }
+			}
+		}
+	}
+	if h == nil {
+		return fmt.Errorf("%w for %s", ErrNoRoute, Format(t))
+	}
+
+	err := h(t)
+	if err != nil && r.Error != nil {
+		err = r.Error(t, err)
+	}
+	return err
+}
+
+// Serve reads terms by calling next until it returns an error and
+// dispatches each of them. next is usually the Decode method of a
+// Decoder or the ReadTerm method of a FramedReader. Serve returns nil
+// if next returns io.EOF, and otherwise the first error returned by
+// next or Dispatch.
+func (r *Router) Serve(next func() (Term, error)) error {
+	for {
+		t, err := next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.Dispatch(t); err != nil {
+			return err
+		}
+	}
+}
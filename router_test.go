@@ -0,0 +1,114 @@
+package etf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type testCall struct {
+	From    Tuple
+	Request Term
+}
+
+type testCast struct {
+	Request Atom
+}
+
+func TestRouter(t *testing.T) {
+	var calls []testCall
+	var casts []Atom
+	var other []Term
+	var results []Term
+
+	var r Router
+	Handle(&r, "call", func(c testCall) error {
+		calls = append(calls, c)
+		return nil
+	})
+	Handle(&r, "cast", func(c testCast) error {
+		casts = append(casts, c.Request)
+		return nil
+	})
+	r.HandleFunc("stop", 1, func(Tuple) error { return errors.New("stopped") })
+	r.HandleFunc("true", 2, func(t Tuple) error {
+		results = append(results, t[1])
+		return nil
+	})
+	r.Fallback = func(t Term) error {
+		other = append(other, t)
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewFramedWriter(buf, 4)
+	for _, term := range []Term{
+		Tuple{Atom("call"), Tuple{Pid{Node: "a@h"}, Ref{Node: "a@h", Id: []uint32{1}}}, Tuple{Atom("get"), 1}},
+		Tuple{Atom("cast"), Atom("ping")},
+		Tuple{Atom("cast"), Atom("a"), Atom("b")},
+		Tuple{true, 1},
+		Atom("hello"),
+		Tuple{Atom("stop")},
+		Tuple{Atom("cast"), Atom("unreached")},
+	} {
+		if err := w.WriteTerm(term); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := r.Serve(NewFramedReader(buf, 4).ReadTerm)
+	if err == nil || err.Error() != "stopped" {
+		t.Fatalf("expected stopped, got %v", err)
+	}
+	if len(calls) != 1 || !Equal(calls[0].Request, Tuple{Atom("get"), 1}) {
+		t.Errorf("unexpected calls %v", calls)
+	}
+	if len(casts) != 1 || casts[0] != "ping" {
+		t.Errorf("unexpected casts %v", casts)
+	}
+	if len(other) != 2 || !Equal(other[1], Atom("hello")) {
+		t.Errorf("unexpected fallback terms %v", other)
+	}
+	if len(results) != 1 || !Equal(results[0], 1) {
+		t.Errorf("unexpected true results %v", results)
+	}
+}
+
+func TestRouterErrors(t *testing.T) {
+	var r Router
+	Handle(&r, "cast", func(c testCast) error { return nil })
+
+	err := r.Dispatch(Tuple{Atom("call"), 1})
+	if !errors.Is(err, ErrNoRoute) {
+		t.Errorf("expected ErrNoRoute, got %v", err)
+	}
+
+	err = r.Dispatch(Tuple{Atom("cast"), 1})
+	var cerr *ConversionError
+	if !errors.As(err, &cerr) {
+		t.Errorf("expected ConversionError, got %v", err)
+	}
+
+	var handled []error
+	r.Error = func(t Term, err error) error {
+		handled = append(handled, err)
+		return nil
+	}
+	d := new(Context).Decoder(bytes.NewReader([]byte{
+		131, 104, 2, 119, 4, 'c', 'a', 's', 't', 97, 1,
+		131, 104, 2, 119, 4, 'c', 'a', 's', 't', 119, 2, 'o', 'k',
+	}))
+	if err := r.Serve(d.Decode); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 1 {
+		t.Errorf("expected 1 handled error, got %v", handled)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for duplicate route")
+		}
+	}()
+	r.HandleFunc("cast", 2, func(Tuple) error { return nil })
+}
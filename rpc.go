@@ -0,0 +1,215 @@
+package etf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BadRPCError is returned by Node.Call when the call fails, with the
+// reason that rpc:call/5 would return as {badrpc, Reason}.
+type BadRPCError struct {
+	Reason Term
+}
+
+func (err *BadRPCError) Error() string {
+	return fmt.Sprintf("rpc: badrpc: %s", Format(err.Reason))
+}
+
+// Call calls module:function with args on the node called node and
+// returns the result, like rpc:call/5. The other node must be
+// connected with ServeConn. If the call fails on the other node, or if
+// no reply arrives within timeout, Call returns a *BadRPCError with the
+// reason, such as {'EXIT', Reason} or timeout. A timeout of 0 or less
+// waits forever.
+//
+// If node is n itself, the call is sent to the process registered as
+// rex on n. NewNode doesn't start one, since a Node has no functions to
+// call, so such calls only work if the program registers a process
+// that answers rex calls, and otherwise fail with ErrNoProcess.
+//
+// If the connection's Flags include DistFlagSpawn, the call is made the
+// way that erpc makes it, by spawning a process on the other node with
+// a SPAWN_REQUEST and monitoring it for the result. Otherwise, it is
+// sent to the rex server of the other node. Either way, the results
+// are the same as rpc:call/5's, so a value thrown by the function is
+// returned as its result.
+//
+// The group leader of the called function is a process on n that
+// accepts output requests and discards the output, and rejects input
+// requests, so that I/O done by the function doesn't block it.
+func (n *Node) Call(node, module, function Atom, args List, timeout time.Duration) (Term, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var conn *DistConn
+	if node != n.name {
+		var err error
+		if conn, err = n.conn(node); err != nil {
+			return nil, err
+		}
+	}
+
+	p := n.NewProcess()
+	defer p.Exit(nil)
+
+	if conn != nil && conn.Flags&DistFlagSpawn != 0 {
+		return n.erpcCall(ctx, p, conn, module, function, args)
+	}
+
+	ref := n.MakeRef()
+	call := GenCall{
+		From:    NewFrom(p.pid, ref, false),
+		Request: Tuple{Atom("call"), module, function, args, n.groupLeader()},
+	}
+	if err := p.Send(Tuple{Atom("rex"), node}, call.Tuple()); err != nil {
+		return nil, err
+	}
+
+	_, b, err := p.ReceiveMatch(ctx, Tuple{ref, Var("Reply")})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, &BadRPCError{Atom("timeout")}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reply := b["Reply"]
+	var reason Term
+	if MatchTuple(reply, Atom("badrpc"), &reason) == nil {
+		return nil, &BadRPCError{reason}
+	}
+	return reply, nil
+}
+
+// erpcCall makes a call the way that erpc:call/5 does. It spawns
+// erpc:execute_call(Res, Module, Function, Args) on the other node with
+// a monitor, which exits with the result as its reason: {Res, return,
+// Value}, {Res, throw, Value}, {Res, exit, Reason} or {Res, error,
+// Reason, Stack}.
+func (n *Node) erpcCall(ctx context.Context, p *Process, conn *DistConn, module, function Atom, args List) (Term, error) {
+	reqID, res := n.MakeRef(), n.MakeRef()
+	err := conn.WriteMessage(
+		Tuple{DistSpawnRequest, reqID, p.pid, n.groupLeader(), Tuple{Atom("erpc"), Atom("execute_call"), 4}, List{Atom("monitor")}},
+		List{res, module, function, args},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var spawned Pid
+	spawnOK := Tuple{Atom("spawn_reply"), reqID, Atom("ok"), Var("Pid")}
+	spawnError := Tuple{Atom("spawn_reply"), reqID, Atom("error"), Var("Reason")}
+	down := Tuple{Atom("DOWN"), reqID, Atom("process"), Any, Var("Reason")}
+	for {
+		var b Bindings
+		msg, err := p.ReceiveFunc(ctx, func(t Term) bool {
+			for _, pattern := range []Term{spawnOK, spawnError, down} {
+				var ok bool
+				if b, ok = Match(pattern, t); ok {
+					return true
+				}
+			}
+			return false
+		})
+		if errors.Is(err, context.DeadlineExceeded) {
+			if spawned != (Pid{}) {
+				conn.WriteMessage(Tuple{DistDemonitorP, p.pid, spawned, reqID})
+			}
+			return nil, &BadRPCError{Atom("timeout")}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg.(Tuple)[0] {
+		case Atom("spawn_reply"):
+			if pid, ok := b["Pid"].(Pid); ok {
+				spawned = pid
+				continue
+			}
+			return nil, &BadRPCError{exitReason(b["Reason"])}
+
+		case Atom("DOWN"):
+			reason := b["Reason"]
+			var class Atom
+			var value, stack Term
+			switch {
+			case MatchTuple(reason, res, &class, &value) == nil && (class == "return" || class == "throw"):
+				return value, nil
+			case MatchTuple(reason, res, Atom("exit"), &value) == nil:
+				return nil, &BadRPCError{Tuple{Atom("EXIT"), value}}
+			case MatchTuple(reason, res, Atom("error"), &value, &stack) == nil:
+				return nil, &BadRPCError{Tuple{Atom("EXIT"), Tuple{value, stack}}}
+			}
+			return nil, &BadRPCError{exitReason(reason)}
+		}
+	}
+}
+
+// exitReason returns the badrpc reason for a process that couldn't be
+// spawned or exited without a result, as rpc:call/5 reports it.
+func exitReason(reason Term) Term {
+	if reason == Atom("noconnection") {
+		return Atom("nodedown")
+	}
+	return Tuple{Atom("EXIT"), reason}
+}
+
+// groupLeader returns the pid of the process that is the group leader
+// of the functions called by Call, starting it if necessary. It is an
+// I/O server that discards output and rejects input.
+func (n *Node) groupLeader() Pid {
+	n.glOnce.Do(func() {
+		n.gl = n.Spawn(func(p *Process) error {
+			for {
+				msg, err := p.Receive(context.Background())
+				if err != nil {
+					return err
+				}
+
+				var from Pid
+				var replyAs, req Term
+				if MatchTuple(msg, Atom("io_request"), &from, &replyAs, &req) == nil {
+					p.Send(from, Tuple{Atom("io_reply"), replyAs, ioReply(req)})
+				}
+			}
+		})
+	})
+	return n.gl
+}
+
+// ioReply returns the reply of the group leader to an I/O request.
+func ioReply(req Term) Term {
+	if req == Atom("getopts") {
+		return List{}
+	}
+
+	t, _ := req.(Tuple)
+	if len(t) == 0 {
+		return Tuple{Atom("error"), Atom("request")}
+	}
+	switch t[0] {
+	case Atom("put_chars"):
+		return Atom("ok")
+
+	case Atom("requests"):
+		reqs, _ := t.Get(2)
+		elems, _, ok := listOf(reqs)
+		if !ok {
+			break
+		}
+		for _, r := range elems {
+			if reply := ioReply(r); reply != Atom("ok") {
+				return reply
+			}
+		}
+		return Atom("ok")
+	}
+	return Tuple{Atom("error"), Atom("enotsup")}
+}
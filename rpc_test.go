@@ -0,0 +1,290 @@
+package etf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// startRex registers a process on n that answers rex calls the way
+// that an Erlang node does, for a few functions.
+func startRex(t *testing.T, n *Node) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	pid := n.Spawn(func(p *Process) error {
+		var r Router
+		Handle(&r, GenCallTag, func(c GenCall) error {
+			var mod, fun Atom
+			var args List
+			if err := MatchTuple(c.Request, Atom("call"), &mod, &fun, &args, nil); err != nil {
+				return err
+			}
+
+			var result Term
+			switch {
+			case mod == "erlang" && fun == "node" && len(args) == 0:
+				result = n.Name()
+			case mod == "lists" && fun == "reverse" && len(args) == 1:
+				l, err := As[List](args[0])
+				if err != nil {
+					result = Tuple{Atom("badrpc"), Tuple{Atom("EXIT"), Tuple{Atom("function_clause"), List{}}}}
+					break
+				}
+				result = slices.Clone(l)
+				slices.Reverse(result.(List))
+			case mod == "timer" && fun == "sleep":
+				return nil
+			default:
+				result = Tuple{Atom("badrpc"), Tuple{Atom("EXIT"), Tuple{Atom("undef"), List{}}}}
+			}
+			return p.Send(c.From.ReplyTo(), c.From.Reply(result))
+		})
+		return r.Serve(func() (Term, error) { return p.Receive(ctx) })
+	})
+	if err := n.Register("rex", pid); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	client := NewNode("go@client", 1)
+	server := NewNode("go@server", 2)
+	startRex(t, server)
+
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	go client.ServeConn("go@server", NewDistConn(a))
+	go server.ServeConn("go@client", NewDistConn(b))
+	for _, n := range []*Node{client, server} {
+		for {
+			n.m.Lock()
+			connected := len(n.conns) == 1
+			n.m.Unlock()
+			if connected {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	v, err := client.Call("go@server", "erlang", "node", List{}, time.Second)
+	if err != nil || !Equal(v, Atom("go@server")) {
+		t.Errorf("erlang:node(): got %v, %v", v, err)
+	}
+
+	v, err = client.Call("go@server", "lists", "reverse", List{List{1, 2, 3}}, time.Second)
+	if err != nil || !Equal(v, List{3, 2, 1}) {
+		t.Errorf("lists:reverse/1: got %v, %v", v, err)
+	}
+
+	_, err = client.Call("go@server", "nope", "nope", List{}, time.Second)
+	var bad *BadRPCError
+	if !errors.As(err, &bad) || !Equal(bad.Reason, Tuple{Atom("EXIT"), Tuple{Atom("undef"), List{}}}) {
+		t.Errorf("undefined function: got %v", err)
+	}
+
+	_, err = client.Call("go@server", "timer", "sleep", List{1000}, 20*time.Millisecond)
+	if !errors.As(err, &bad) || !Equal(bad.Reason, Atom("timeout")) {
+		t.Errorf("timeout: got %v", err)
+	}
+
+	if _, err := client.Call("go@elsewhere", "erlang", "node", List{}, time.Second); err == nil {
+		t.Error("called a node that isn't connected")
+	}
+
+	// Calls to the node itself need a local rex.
+	if _, err := client.Call("go@client", "erlang", "node", List{}, time.Second); !errors.Is(err, ErrNoProcess) {
+		t.Errorf("local call without rex: expected %v, got %v", ErrNoProcess, err)
+	}
+	startRex(t, client)
+	v, err = client.Call("go@client", "erlang", "node", List{}, time.Second)
+	if err != nil || !Equal(v, Atom("go@client")) {
+		t.Errorf("local erlang:node(): got %v, %v", v, err)
+	}
+}
+
+// erpcCall is a call that serveErpc has received and not yet answered.
+type erpcCall struct {
+	from Pid
+	ref  Term
+	res  Term
+}
+
+// serveErpc answers the spawn requests that Call makes on conn the way
+// that an Erlang node running erpc:execute_call/4 does, for a few
+// functions. It sends the pids that the calls demonitor to demonitored.
+func serveErpc(conn *DistConn, node Atom, demonitored chan<- Pid) {
+	pending := make(map[uint32]erpcCall)
+	exit := func(pid Pid, reason Term) {
+		c := pending[pid.Id]
+		delete(pending, pid.Id)
+		conn.WriteMessage(Tuple{DistMonitorPExit, pid, c.from, c.ref, reason})
+	}
+
+	var nextID uint32
+	for {
+		control, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		switch control[0] {
+		case DistSpawnRequest:
+			var ref, gl Term
+			var from Pid
+			if MatchTuple(control, DistSpawnRequest, &ref, &from, &gl, Tuple{Atom("erpc"), Atom("execute_call"), 4}, List{Atom("monitor")}) != nil {
+				continue
+			}
+			l, _ := msg.(List)
+			if len(l) != 4 {
+				continue
+			}
+			res := l[0]
+			mod, _ := l[1].(Atom)
+			fun, _ := l[2].(Atom)
+			args, _ := l[3].(List)
+			if mod == "erlang" && fun == "spawn" {
+				conn.WriteMessage(Tuple{DistSpawnReply, ref, from, 0, Atom("system_limit")})
+				continue
+			}
+
+			nextID++
+			pid := Pid{Node: node, Id: nextID}
+			pending[pid.Id] = erpcCall{from, ref, res}
+			conn.WriteMessage(Tuple{DistSpawnReply, ref, from, 0, pid})
+
+			switch {
+			case mod == "erlang" && fun == "node":
+				exit(pid, Tuple{res, Atom("return"), node})
+			case mod == "lists" && fun == "reverse" && len(args) == 1:
+				l, _ := As[List](args[0])
+				l = slices.Clone(l)
+				slices.Reverse(l)
+				exit(pid, Tuple{res, Atom("return"), l})
+			case mod == "erlang" && fun == "throw" && len(args) == 1:
+				exit(pid, Tuple{res, Atom("throw"), args[0]})
+			case mod == "erlang" && fun == "exit" && len(args) == 1:
+				exit(pid, Tuple{res, Atom("exit"), args[0]})
+			case mod == "erlang" && fun == "halt":
+				exit(pid, Atom("noconnection"))
+			case mod == "io" && fun == "put_chars" && len(args) == 1:
+				conn.WriteMessage(Tuple{DistSend, Atom(""), gl}, Tuple{Atom("io_request"), pid, pid, Tuple{Atom("put_chars"), Atom("unicode"), args[0]}})
+			case mod == "timer" && fun == "sleep":
+			default:
+				exit(pid, Tuple{res, Atom("error"), Atom("undef"), List{}})
+			}
+
+		case DistSend:
+			var pid Pid
+			var reply Term
+			if MatchTuple(msg, Atom("io_reply"), &pid, &reply) == nil {
+				exit(pid, Tuple{pending[pid.Id].res, Atom("return"), reply})
+			}
+
+		case DistDemonitorP:
+			if pid, ok := control[2].(Pid); ok {
+				delete(pending, pid.Id)
+				demonitored <- pid
+			}
+		}
+	}
+}
+
+func TestCallSpawn(t *testing.T) {
+	client := NewNode("go@client", 1)
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+
+	conn := NewDistConn(a)
+	conn.Flags = DistFlagSpawn
+	go client.ServeConn("go@server", conn)
+	demonitored := make(chan Pid, 1)
+	go serveErpc(NewDistConn(b), "go@server", demonitored)
+	for {
+		client.m.Lock()
+		connected := len(client.conns) == 1
+		client.m.Unlock()
+		if connected {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	v, err := client.Call("go@server", "erlang", "node", List{}, time.Second)
+	if err != nil || !Equal(v, Atom("go@server")) {
+		t.Errorf("erlang:node(): got %v, %v", v, err)
+	}
+
+	v, err = client.Call("go@server", "lists", "reverse", List{List{1, 2, 3}}, time.Second)
+	if err != nil || !Equal(v, List{3, 2, 1}) {
+		t.Errorf("lists:reverse/1: got %v, %v", v, err)
+	}
+
+	v, err = client.Call("go@server", "erlang", "throw", List{Atom("ball")}, time.Second)
+	if err != nil || !Equal(v, Atom("ball")) {
+		t.Errorf("erlang:throw/1: got %v, %v", v, err)
+	}
+
+	v, err = client.Call("go@server", "io", "put_chars", List{"hello"}, time.Second)
+	if err != nil || !Equal(v, Atom("ok")) {
+		t.Errorf("io:put_chars/1: got %v, %v", v, err)
+	}
+
+	for _, test := range []struct {
+		mod, fun Atom
+		args     List
+		reason   Term
+	}{
+		{"erlang", "exit", List{Atom("bye")}, Tuple{Atom("EXIT"), Atom("bye")}},
+		{"nope", "nope", List{}, Tuple{Atom("EXIT"), Tuple{Atom("undef"), List{}}}},
+		{"erlang", "spawn", List{}, Tuple{Atom("EXIT"), Atom("system_limit")}},
+		{"erlang", "halt", List{}, Atom("nodedown")},
+	} {
+		_, err := client.Call("go@server", test.mod, test.fun, test.args, time.Second)
+		var bad *BadRPCError
+		if !errors.As(err, &bad) || !Equal(bad.Reason, test.reason) {
+			t.Errorf("%s:%s/%d: got %v, want badrpc %v", test.mod, test.fun, len(test.args), err, Format(test.reason))
+		}
+	}
+
+	_, err = client.Call("go@server", "timer", "sleep", List{1000}, 20*time.Millisecond)
+	var bad *BadRPCError
+	if !errors.As(err, &bad) || !Equal(bad.Reason, Atom("timeout")) {
+		t.Errorf("timeout: got %v", err)
+	}
+	select {
+	case <-demonitored:
+	case <-time.After(time.Second):
+		t.Error("timeout didn't demonitor the spawned process")
+	}
+}
+
+func TestGroupLeader(t *testing.T) {
+	n := NewNode("go@localhost", 1)
+	p := n.NewProcess()
+	defer p.Exit(nil)
+
+	for _, test := range []struct {
+		req, reply Term
+	}{
+		{Tuple{Atom("put_chars"), Atom("unicode"), "hello"}, Atom("ok")},
+		{Tuple{Atom("requests"), List{Tuple{Atom("put_chars"), Atom("latin1"), "a"}, Tuple{Atom("put_chars"), Atom("latin1"), "b"}}}, Atom("ok")},
+		{Atom("getopts"), List{}},
+		{Tuple{Atom("get_line"), Atom("unicode"), "> "}, Tuple{Atom("error"), Atom("enotsup")}},
+	} {
+		ref := n.MakeRef()
+		if err := p.Send(n.groupLeader(), Tuple{Atom("io_request"), p.Pid(), ref, test.req}); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, b, err := p.ReceiveMatch(ctx, Tuple{Atom("io_reply"), ref, Var("Reply")})
+		cancel()
+		if err != nil || !Equal(b["Reply"], test.reply) {
+			t.Errorf("%s: got %v, %v, want %s", Format(test.req), b["Reply"], err, Format(test.reply))
+		}
+	}
+}
@@ -1,28 +1,242 @@
 package etf
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"math"
 	"math/big"
 	"reflect"
+	"unicode/utf8"
 )
 
+// An Encoder writes terms to an io.Writer. Terms are buffered until
+// Flush is called, which Encode and EncodeTerm do automatically, so
+// that each term is written with a single call to the underlying
+// writer.
 type Encoder struct {
-	c *Context
-	w io.Writer
+	c    *Context
+	w    io.Writer
+	opts EncoderOptions
+	buf  bytes.Buffer
+
+	// path holds the positions of the terms currently being encoded
+	// inside of their parents, for errors.
+	path []pathElem
 }
 
-func (e *Encoder) Encode(term any) (err error) {
-	_, err = e.w.Write([]byte{EtVersion})
-	if err != nil {
+// Reset makes the Encoder write to w, keeping its options and
+// discarding any unflushed data. It allows an Encoder and its buffer
+// to be reused instead of allocating new ones.
+func (e *Encoder) Reset(w io.Writer) {
+	e.w = w
+	e.buf.Reset()
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (e *Encoder) Flush() error {
+	if e.buf.Len() == 0 {
+		return nil
+	}
+	_, err := e.w.Write(e.buf.Bytes())
+	e.buf.Reset()
+	return err
+}
+
+// Buffered returns the number of bytes that haven't been flushed.
+func (e *Encoder) Buffered() int {
+	return e.buf.Len()
+}
+
+// header writes the header of a term.
+func (e *Encoder) header(b ...byte) error {
+	_, err := e.buf.Write(b)
+	return err
+}
+
+// MaxAtomLength is the maximum number of characters in an atom.
+const MaxAtomLength = 255
+
+// EncoderOptions configures how an Encoder writes terms.
+type EncoderOptions struct {
+	// Strings selects how Go strings are encoded. It can be overridden
+	// for individual struct fields with an etf struct tag containing
+	// "bytes", "binary" or "charlist".
+	Strings StringEncoding
+
+	// Target is the major version of the oldest OTP release that the
+	// encoded terms must be understood by, such as 21. It selects
+	// which tags the Encoder uses:
+	//
+	//   - Before OTP 20, atoms are encoded using Latin-1, as with
+	//     Latin1Atoms.
+	//   - From OTP 23, pids, ports and references are encoded using
+	//     NEW_PID_EXT, NEW_PORT_EXT and NEWER_REFERENCE_EXT, which have
+	//     32-bit creations. Before that, they are only used, from OTP
+	//     19, for values that don't fit in PID_EXT, PORT_EXT or
+	//     NEW_REFERENCE_EXT, and values that don't fit in either are
+	//     an error.
+	//   - From OTP 24, references can have up to 5 ID words instead
+	//     of 3.
+	//   - From OTP 24, ports with IDs that don't fit in 32 bits are
+	//     encoded using V4_PORT_EXT.
+	//
+	// LOCAL_EXT, added in OTP 26, is never used, because only the node
+	// that creates such a term can decode it.
+	//
+	// The zero value targets LatestOTP.
+	Target int
+
+	// Packet is the size in bytes of a big-endian length header that
+	// is written before each term written by Encode, as with gen_tcp's
+	// {packet, N} option. It must be 0, 1, 2 or 4. If it is 0, no
+	// header is written.
+	Packet int
+
+	// Latin1Atoms makes the Encoder write atoms using the Latin-1
+	// ATOM_EXT and SMALL_ATOM_EXT tags instead of their UTF-8
+	// equivalents, for nodes older than OTP 20 that don't understand
+	// them. Atoms containing characters outside of Latin-1 can't be
+	// encoded when it is enabled.
+	Latin1Atoms bool
+}
+
+// LatestOTP is the newest OTP release that the Encoder can target.
+const LatestOTP = 26
+
+// StringEncoding selects how Go strings are encoded.
+type StringEncoding int
+
+const (
+	// StringAsBytes encodes a Go string as a list of its bytes, using
+	// STRING_EXT when it is short enough to fit and LIST_EXT
+	// otherwise. This is the inverse of how STRING_EXT is decoded by
+	// default, but Erlang will see UTF-8 text as a list of Latin-1
+	// characters.
+	StringAsBytes StringEncoding = iota
+
+	// StringAsBinary encodes a Go string as a binary containing its
+	// bytes, which is how Elixir represents strings.
+	StringAsBinary
+
+	// StringAsCharlist encodes a Go string as a list of its code
+	// points, which is how Erlang represents strings. STRING_EXT is
+	// used when every code point is in Latin-1 and there are few
+	// enough of them to fit, and LIST_EXT otherwise. The string must
+	// be valid UTF-8.
+	StringAsCharlist
+)
+
+// stringEncodings maps the values accepted in etf struct tags to the
+// string encodings that they select.
+var stringEncodings = map[string]StringEncoding{
+	"bytes":    StringAsBytes,
+	"binary":   StringAsBinary,
+	"charlist": StringAsCharlist,
+}
+
+// An EncoderOption sets an option on an Encoder.
+type EncoderOption func(*EncoderOptions)
+
+// EncodeStringsAs sets how Go strings are encoded.
+func EncodeStringsAs(mode StringEncoding) EncoderOption {
+	return func(o *EncoderOptions) { o.Strings = mode }
+}
+
+// TargetOTP sets the oldest OTP release that encoded terms must be
+// understood by.
+func TargetOTP(release int) EncoderOption {
+	return func(o *EncoderOptions) { o.Target = release }
+}
+
+// EncodePacket sets the size of the length header written before each
+// term written by Encode.
+func EncodePacket(size int) EncoderOption {
+	return func(o *EncoderOptions) { o.Packet = size }
+}
+
+// EncodeLatin1Atoms enables or disables writing atoms using Latin-1.
+func EncodeLatin1Atoms(enabled bool) EncoderOption {
+	return func(o *EncoderOptions) { o.Latin1Atoms = enabled }
+}
+
+// targets reports whether the Encoder may use features of the given
+// OTP release.
+func (e *Encoder) targets(release int) bool {
+	return e.opts.Target == 0 || e.opts.Target >= release
+}
+
+func (e *Encoder) latin1Atoms() bool {
+	return e.opts.Latin1Atoms || !e.targets(20)
+}
+
+// Encode writes a term, preceded by the version byte and, if
+// configured, a length header, and flushes it to the underlying
+// writer. If the term can't be encoded, nothing is written.
+func (e *Encoder) Encode(term any) error {
+	if err := e.frame(term); err != nil {
 		return err
 	}
+	return e.Flush()
+}
 
-	return e.EncodeTerm(term)
+// frame buffers a term as Encode writes it, without flushing it.
+func (e *Encoder) frame(term any) (err error) {
+	switch e.opts.Packet {
+	case 0, 1, 2, 4:
+	default:
+		return fmt.Errorf("invalid packet size %d", e.opts.Packet)
+	}
+
+	// The header is filled in once the size of the term is known.
+	var header [4]byte
+	start := e.buf.Len()
+	e.buf.Write(header[:e.opts.Packet])
+	e.header(EtVersion)
+	if err = e.encodeTerm(term); err != nil {
+		e.buf.Truncate(start)
+		return err
+	}
+
+	if e.opts.Packet != 0 {
+		frame := e.buf.Bytes()[start:]
+		size := uint64(len(frame) - e.opts.Packet)
+		if size >= 1<<(8*e.opts.Packet) {
+			e.buf.Truncate(start)
+			return fmt.Errorf("term of %d bytes is too big for a %d byte length header", size, e.opts.Packet)
+		}
+		for i := e.opts.Packet - 1; i >= 0; i-- {
+			frame[i] = byte(size)
+			size >>= 8
+		}
+	}
+
+	return nil
+}
+
+// encodeAt writes a term at the given position inside of its parent.
+func (e *Encoder) encodeAt(elem pathElem, term any) error {
+	e.path = append(e.path, elem)
+	err := e.encodeTerm(term)
+	e.path = e.path[:len(e.path)-1]
+	return err
 }
 
-func (e *Encoder) EncodeTerm(term any) (err error) {
+// EncodeTerm writes a term without a version byte or length header and
+// flushes it to the underlying writer. If the term can't be encoded,
+// nothing is written.
+func (e *Encoder) EncodeTerm(term any) error {
+	start := e.buf.Len()
+	if err := e.encodeTerm(term); err != nil {
+		e.buf.Truncate(start)
+		return err
+	}
+	return e.Flush()
+}
+
+// encodeTerm buffers a term as EncodeTerm writes it, without flushing
+// it.
+func (e *Encoder) encodeTerm(term any) (err error) {
 	switch v := term.(type) {
 	case bool:
 		err = e.writeBool(v)
@@ -31,11 +245,21 @@ func (e *Encoder) EncodeTerm(term any) (err error) {
 	case uint8, uint16, uint32, uint64, uintptr, uint:
 		err = e.writeUint(reflect.ValueOf(term).Uint())
 	case *big.Int:
-		err = e.writeBigInt(v)
+		err = e.writeInteger(v)
+	case big.Int:
+		err = e.writeInteger(&v)
+	case *big.Rat:
+		err = e.writeRat(v)
+	case big.Rat:
+		err = e.writeRat(&v)
 	case string:
 		err = e.writeString(v)
+	case Charlist:
+		err = e.writeStringAs(string(v), StringAsCharlist)
 	case []byte:
 		err = e.writeBinary(v)
+	case Binary:
+		err = e.writeBinary(v)
 	case float64:
 		err = e.writeFloat(v)
 	case float32:
@@ -44,10 +268,20 @@ func (e *Encoder) EncodeTerm(term any) (err error) {
 		err = e.writeAtom(v)
 	case Pid:
 		err = e.writePid(v)
+	case Port:
+		err = e.writePort(v)
 	case Tuple:
 		err = e.writeTuple(v)
+	case ImproperList:
+		err = e.writeImproperList(v)
+	case Map:
+		err = e.writeMap(v)
 	case Ref:
 		err = e.writeRef(v)
+	case Export:
+		err = e.writeExport(v)
+	case Function:
+		err = e.writeFun(v)
 	default:
 		rv := reflect.ValueOf(v)
 		switch rv.Kind() {
@@ -56,10 +290,19 @@ func (e *Encoder) EncodeTerm(term any) (err error) {
 		case reflect.Array, reflect.Slice:
 			err = e.writeList(term)
 		case reflect.Ptr:
-			err = e.EncodeTerm(rv.Elem())
+			if rv.IsNil() {
+				err = &ErrUnknownType{rv.Type(), formatPath(e.path)}
+				break
+			}
+			err = e.encodeTerm(rv.Elem().Interface())
 		//case reflect.Map // FIXME
 		default:
-			err = &ErrUnknownType{rv.Type()}
+			// rv.Type() is nil for a nil interface.
+			var t reflect.Type
+			if rv.IsValid() {
+				t = rv.Type()
+			}
+			err = &ErrUnknownType{t, formatPath(e.path)}
 		}
 	}
 
@@ -67,27 +310,55 @@ func (e *Encoder) EncodeTerm(term any) (err error) {
 }
 
 func (e *Encoder) writeAtom(atom Atom) (err error) {
-	switch size := len(atom); {
-	case size <= math.MaxUint8:
-		// $sL…
-		if _, err = e.w.Write([]byte{ettSmallAtom, byte(size)}); err == nil {
-			_, err = io.WriteString(e.w, string(atom))
+	if !utf8.ValidString(string(atom)) {
+		return fmt.Errorf("atom is not valid UTF-8 (%q)", atom)
+	}
+	if n := utf8.RuneCountInString(string(atom)); n > MaxAtomLength {
+		return fmt.Errorf("atom is too long (%d characters)", n)
+	}
+
+	text := string(atom)
+	small, large := byte(ettSmallAtomUTF8), byte(ettAtomUTF8)
+	if e.latin1Atoms() {
+		small, large = ettSmallAtom, ettAtom
+		b, err := latin1(atom)
+		if err != nil {
+			return err
 		}
+		text = string(b)
+	}
 
-	case size <= math.MaxUint16:
-		// $dLL…
-		_, err = e.w.Write([]byte{ettAtom, byte(size >> 8), byte(size)})
-		if err == nil {
-			_, err = io.WriteString(e.w, string(atom))
+	switch size := len(text); {
+	case size <= math.MaxUint8:
+		// $wL… | $sL…
+		if err = e.header(small, byte(size)); err == nil {
+			_, err = e.buf.WriteString(text)
 		}
 
 	default:
-		err = fmt.Errorf("atom is too big (%d bytes)", size)
+		// $vLL… | $dLL…
+		// An atom of 255 UTF-8 characters is at most 1020 bytes.
+		err = e.header(large, byte(size>>8), byte(size))
+		if err == nil {
+			_, err = e.buf.WriteString(text)
+		}
 	}
 
 	return
 }
 
+// latin1 returns the Latin-1 encoding of an atom.
+func latin1(atom Atom) ([]byte, error) {
+	text := make([]byte, 0, len(atom))
+	for _, c := range atom {
+		if c > math.MaxUint8 {
+			return nil, fmt.Errorf("atom %q can't be encoded as Latin-1", atom)
+		}
+		text = append(text, byte(c))
+	}
+	return text, nil
+}
+
 func (e *Encoder) writeBigInt(x *big.Int) (err error) {
 	sign := 0
 	if x.Sign() < 0 {
@@ -99,37 +370,55 @@ func (e *Encoder) writeBigInt(x *big.Int) (err error) {
 	switch size := int64(len(bytes)); {
 	case size <= math.MaxUint8:
 		// $nAS…
-		_, err = e.w.Write([]byte{ettSmallBig, byte(size), byte(sign)})
+		err = e.header(ettSmallBig, byte(size), byte(sign))
 
 	case size <= math.MaxUint32:
 		// $oAAAAS…
-		_, err = e.w.Write([]byte{
+		err = e.header(
 			ettLargeBig,
-			byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size),
+			byte(size>>24), byte(size>>16), byte(size>>8), byte(size),
 			byte(sign),
-		})
+		)
 
 	default:
 		err = fmt.Errorf("bad big int size (%d)", size)
 	}
 
 	if err == nil {
-		_, err = e.w.Write(bytes)
+		_, err = e.buf.Write(bytes)
 	}
 
 	return
 }
 
+// writeInteger writes an arbitrarily sized integer using the smallest
+// encoding that can hold it, as term_to_binary does.
+func (e *Encoder) writeInteger(x *big.Int) error {
+	if x.IsInt64() {
+		return e.writeInt(x.Int64())
+	}
+	return e.writeBigInt(x)
+}
+
+// writeRat writes a rational number, which must be an integer, since
+// Erlang has no rational type.
+func (e *Encoder) writeRat(x *big.Rat) error {
+	if !x.IsInt() {
+		return fmt.Errorf("rational %v is not an integer", x)
+	}
+	return e.writeInteger(x.Num())
+}
+
 func (e *Encoder) writeBinary(bytes []byte) (err error) {
 	switch size := int64(len(bytes)); {
 	case size <= math.MaxUint32:
 		// $mLLLL…
-		data := []byte{
+		err = e.header(
 			ettBinary,
-			byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size),
-		}
-		if _, err = e.w.Write(data); err == nil {
-			_, err = e.w.Write(bytes)
+			byte(size>>24), byte(size>>16), byte(size>>8), byte(size),
+		)
+		if err == nil {
+			_, err = e.buf.Write(bytes)
 		}
 
 	default:
@@ -140,23 +429,27 @@ func (e *Encoder) writeBinary(bytes []byte) (err error) {
 }
 
 func (e *Encoder) writeBool(b bool) (err error) {
-	// $sL…
+	// $wL… | $sL…
+	tag := byte(ettSmallAtomUTF8)
+	if e.latin1Atoms() {
+		tag = ettSmallAtom
+	}
 	if b {
-		_, err = e.w.Write([]byte{ettSmallAtom, 4, 't', 'r', 'u', 'e'})
+		err = e.header(tag, 4, 't', 'r', 'u', 'e')
 	} else {
-		_, err = e.w.Write([]byte{ettSmallAtom, 5, 'f', 'a', 'l', 's', 'e'})
+		err = e.header(tag, 5, 'f', 'a', 'l', 's', 'e')
 	}
 
 	return
 }
 
 func (e *Encoder) writeFloat(f float64) (err error) {
-	if _, err = e.w.Write([]byte{ettNewFloat}); err == nil {
+	if err = e.header(ettNewFloat); err == nil {
 		fb := math.Float64bits(f)
-		_, err = e.w.Write([]byte{
-			byte(fb >> 56), byte(fb >> 48), byte(fb >> 40), byte(fb >> 32),
-			byte(fb >> 24), byte(fb >> 16), byte(fb >> 8), byte(fb),
-		})
+		err = e.header(
+			byte(fb>>56), byte(fb>>48), byte(fb>>40), byte(fb>>32),
+			byte(fb>>24), byte(fb>>16), byte(fb>>8), byte(fb),
+		)
 	}
 	return
 }
@@ -165,15 +458,15 @@ func (e *Encoder) writeInt(x int64) (err error) {
 	switch {
 	case x >= 0 && x <= math.MaxUint8:
 		// $aI
-		_, err = e.w.Write([]byte{ettSmallInteger, byte(x)})
+		err = e.header(ettSmallInteger, byte(x))
 
 	case x >= math.MinInt32 && x <= math.MaxInt32:
 		// $bIIII
 		x := int32(x)
-		_, err = e.w.Write([]byte{
+		err = e.header(
 			ettInteger,
-			byte(x >> 24), byte(x >> 16), byte(x >> 8), byte(x),
-		})
+			byte(x>>24), byte(x>>16), byte(x>>8), byte(x),
+		)
 
 	default:
 		err = e.writeBigInt(big.NewInt(x))
@@ -186,14 +479,14 @@ func (e *Encoder) writeUint(x uint64) (err error) {
 	switch {
 	case x <= math.MaxUint8:
 		// $aI
-		_, err = e.w.Write([]byte{ettSmallInteger, byte(x)})
+		err = e.header(ettSmallInteger, byte(x))
 
 	case x <= math.MaxInt32:
 		// $bIIII
-		_, err = e.w.Write([]byte{
+		err = e.header(
 			ettInteger,
-			byte(x >> 24), byte(x >> 16), byte(x >> 8), byte(x),
-		})
+			byte(x>>24), byte(x>>16), byte(x>>8), byte(x),
+		)
 
 	default:
 		err = e.writeBigInt(new(big.Int).SetUint64(x))
@@ -203,50 +496,200 @@ func (e *Encoder) writeUint(x uint64) (err error) {
 }
 
 func (e *Encoder) writePid(p Pid) (err error) {
-	if _, err = e.w.Write([]byte{ettPid}); err != nil {
+	// $gA…IIIISSSSC | $XA…IIIISSSSCCCC
+	fits := p.Id < 1<<15 && p.Serial < 1<<13 && p.Creation < 1<<2
+	tag, err := e.identifierTag(ettPid, ettNewPid, fits)
+	if err != nil {
+		return fmt.Errorf("pid %v: %w", p, err)
+	}
+	if err = e.header(tag); err != nil {
 		return
 	} else if err = e.writeAtom(p.Node); err != nil {
 		return
 	}
 
-	_, err = e.w.Write([]byte{
-		0, 0, byte(p.Id >> 8), byte(p.Id),
-		byte(p.Serial >> 24),
-		byte(p.Serial >> 16),
-		byte(p.Serial >> 8),
+	err = e.header(
+		byte(p.Id>>24),
+		byte(p.Id>>16),
+		byte(p.Id>>8),
+		byte(p.Id),
+		byte(p.Serial>>24),
+		byte(p.Serial>>16),
+		byte(p.Serial>>8),
 		byte(p.Serial),
-		p.Creation,
-	})
+	)
+	if err != nil {
+		return
+	}
+
+	return e.writeCreation(tag, p.Creation)
+}
 
+func (e *Encoder) writePort(p Port) (err error) {
+	// $fA…IIIIC | $YA…IIIICCCC | $xA…IIIIIIIICCCC
+	tag, idSize := byte(ettV4Port), 8
+	if p.Id <= math.MaxUint32 {
+		fits := p.Id < 1<<28 && p.Creation < 1<<2
+		if tag, err = e.identifierTag(ettPort, ettNewPort, fits); err != nil {
+			return fmt.Errorf("port %v: %w", p, err)
+		}
+		idSize = 4
+	} else if !e.targets(24) {
+		return fmt.Errorf("port %v: ID needs V4_PORT_EXT, which needs OTP 24", p)
+	}
+
+	if err = e.header(tag); err != nil {
+		return
+	} else if err = e.writeAtom(p.Node); err != nil {
+		return
+	}
+
+	id := p.Id
+	if idSize == 8 {
+		if err = e.header(byte(id>>56), byte(id>>48), byte(id>>40), byte(id>>32)); err != nil {
+			return
+		}
+	}
+	if err = e.header(byte(id>>24), byte(id>>16), byte(id>>8), byte(id)); err != nil {
+		return
+	}
+
+	return e.writeCreation(tag, p.Creation)
+}
+
+// identifierTag returns the tag to use for a pid, port or reference,
+// given its old tag, which has an 8-bit creation field and limits the
+// sizes of the other fields, and its new tag, which has a 32-bit
+// creation field. The new tag is used when targeting OTP 23 or later,
+// which always do so, or when the value doesn't fit in the old one and
+// the target is at least OTP 19, which introduced it.
+func (e *Encoder) identifierTag(old, new byte, fits bool) (byte, error) {
+	switch {
+	case e.targets(23):
+		return new, nil
+	case fits:
+		return old, nil
+	case e.targets(19):
+		return new, nil
+	}
+	return 0, fmt.Errorf("doesn't fit in %v, and %v needs OTP 19", tagName(old), tagName(new))
+}
+
+// writeCreation writes the creation field of a pid, port or reference
+// with the given tag.
+func (e *Encoder) writeCreation(tag byte, creation uint32) (err error) {
+	if creationSize(tag) == 4 {
+		err = e.header(
+			byte(creation>>24),
+			byte(creation>>16),
+			byte(creation>>8),
+			byte(creation),
+		)
+		return
+	}
+
+	if creation > math.MaxUint8 {
+		return fmt.Errorf("creation %d needs OTP 23", creation)
+	}
+	err = e.header(byte(creation))
 	return
 }
 
-func (e *Encoder) writeString(s string) (err error) {
-	switch size := len(s); {
-	case size <= math.MaxUint16:
-		// $kLL…
-		_, err = e.w.Write([]byte{ettString, byte(size >> 8), byte(size)})
+func (e *Encoder) writeString(s string) error {
+	return e.writeStringAs(s, e.opts.Strings)
+}
+
+func (e *Encoder) writeStringAs(s string, mode StringEncoding) (err error) {
+	switch mode {
+	case StringAsBinary:
+		// $mLLLL…
+		size := int64(len(s))
+		if size > math.MaxUint32 {
+			return fmt.Errorf("bad binary size (%d)", size)
+		}
+		err = e.header(ettBinary, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
 		if err == nil {
-			_, err = e.w.Write([]byte(s))
+			_, err = e.buf.WriteString(s)
 		}
+		return
 
-	default:
-		err = fmt.Errorf("string is too big (%d bytes)", size)
+	case StringAsCharlist:
+		if !utf8.ValidString(s) {
+			return fmt.Errorf("string is not valid UTF-8 (%q)", s)
+		}
+		runes := []rune(s)
+		if b, ok := latin1Runes(runes); ok && len(b) <= math.MaxUint16 {
+			return e.writeStringExt(string(b))
+		}
+		return e.writeCodes(len(runes), func(i int) int { return int(runes[i]) })
+	}
+
+	if len(s) <= math.MaxUint16 {
+		return e.writeStringExt(s)
+	}
+	return e.writeCodes(len(s), func(i int) int { return int(s[i]) })
+}
+
+// latin1Runes returns runes as Latin-1 bytes if they are all in range.
+func latin1Runes(runes []rune) ([]byte, bool) {
+	b := make([]byte, len(runes))
+	for i, r := range runes {
+		if r > math.MaxUint8 {
+			return nil, false
+		}
+		b[i] = byte(r)
 	}
+	return b, true
+}
 
+func (e *Encoder) writeStringExt(b string) (err error) {
+	// $kLL…
+	size := len(b)
+	err = e.header(ettString, byte(size>>8), byte(size))
+	if err == nil {
+		_, err = e.buf.WriteString(b)
+	}
+	return
+}
+
+// writeCodes writes a proper list of n character codes using LIST_EXT.
+func (e *Encoder) writeCodes(n int, code func(int) int) (err error) {
+	err = e.header(
+		ettList,
+		byte(n>>24),
+		byte(n>>16),
+		byte(n>>8),
+		byte(n),
+	)
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		if err = e.writeInt(int64(code(i))); err != nil {
+			return
+		}
+	}
+
+	err = e.header(ettNil)
 	return
 }
 
 func (e *Encoder) writeList(l any) (err error) {
 	rv := reflect.ValueOf(l)
 	n := rv.Len()
-	_, err = e.w.Write([]byte{
+	if n == 0 {
+		// $j, as term_to_binary writes [].
+		return e.header(ettNil)
+	}
+
+	err = e.header(
 		ettList,
-		byte(n >> 24),
-		byte(n >> 16),
-		byte(n >> 8),
+		byte(n>>24),
+		byte(n>>16),
+		byte(n>>8),
 		byte(n),
-	})
+	)
 
 	if err != nil {
 		return
@@ -254,12 +697,60 @@ func (e *Encoder) writeList(l any) (err error) {
 
 	for i := 0; i < n; i++ {
 		v := rv.Index(i).Interface()
-		if err = e.EncodeTerm(v); err != nil {
+		if err = e.encodeAt(pathElem{kind: pathList, index: i}, v); err != nil {
 			return
 		}
 	}
 
-	_, err = e.w.Write([]byte{ettNil})
+	err = e.header(ettNil)
+
+	return
+}
+
+func (e *Encoder) writeImproperList(l ImproperList) (err error) {
+	n := len(l.Elements)
+	err = e.header(
+		ettList,
+		byte(n>>24),
+		byte(n>>16),
+		byte(n>>8),
+		byte(n),
+	)
+
+	if err != nil {
+		return
+	}
+
+	for i, v := range l.Elements {
+		if err = e.encodeAt(pathElem{kind: pathList, index: i}, v); err != nil {
+			return
+		}
+	}
+
+	return e.encodeAt(pathElem{kind: pathTail}, l.Tail)
+}
+
+func (e *Encoder) writeMap(m Map) (err error) {
+	n := len(m)
+	err = e.header(
+		ettMap,
+		byte(n>>24),
+		byte(n>>16),
+		byte(n>>8),
+		byte(n),
+	)
+
+	if err != nil {
+		return
+	}
+
+	for i, entry := range m {
+		if err = e.encodeAt(pathElem{kind: pathMapKey, index: i}, entry.Key); err != nil {
+			return
+		} else if err = e.encodeAt(pathElem{kind: pathMapValue, index: i}, entry.Value); err != nil {
+			return
+		}
+	}
 
 	return
 }
@@ -267,32 +758,39 @@ func (e *Encoder) writeList(l any) (err error) {
 func (e *Encoder) writeRecord(r any) (err error) {
 	rv := reflect.ValueOf(r)
 	rt := rv.Type()
-	fields := make([]reflect.Value, 0, rt.NumField())
-	for i := 0; i < rt.NumField(); i++ {
-		field := rt.Field(i)
-		if field.Anonymous || !field.IsExported() {
-			continue
-		}
-		fields = append(fields, rv.Field(i))
-	}
+	fields := recordFields(rt)
 
 	if len(fields) <= math.MaxUint8 {
-		_, err = e.w.Write([]byte{ettSmallTuple, byte(len(fields))})
+		err = e.header(ettSmallTuple, byte(len(fields)))
 	} else {
-		_, err = e.w.Write([]byte{
+		err = e.header(
 			ettLargeTuple,
-			byte(len(fields) >> 24),
-			byte(len(fields) >> 16),
-			byte(len(fields) >> 8),
+			byte(len(fields)>>24),
+			byte(len(fields)>>16),
+			byte(len(fields)>>8),
 			byte(len(fields)),
-		})
+		)
 	}
 	if err != nil {
 		return err
 	}
 
-	for _, field := range fields {
-		if err = e.EncodeTerm(field.Interface()); err != nil {
+	for _, i := range fields {
+		field := rv.Field(i)
+		if field.Kind() == reflect.String {
+			if tag, ok := rt.Field(i).Tag.Lookup("etf"); ok {
+				mode, ok := stringEncodings[tag]
+				if !ok {
+					return fmt.Errorf("unknown string encoding %q for field %v", tag, rt.Field(i).Name)
+				}
+				if err = e.writeStringAs(field.String(), mode); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if err = e.encodeAt(pathElem{kind: pathField, name: rt.Field(i).Name}, field.Interface()); err != nil {
 			return err
 		}
 	}
@@ -301,25 +799,34 @@ func (e *Encoder) writeRecord(r any) (err error) {
 }
 
 func (e *Encoder) writeRef(ref Ref) (err error) {
+	// $rLLA…C… | $ZLLA…CCCC…
 	n := len(ref.Id)
-	_, err = e.w.Write([]byte{ettNewRef, byte(n >> 8), byte(n)})
+	maxWords := 3
+	if e.targets(24) {
+		maxWords = 5
+	}
+	if n == 0 || n > maxWords {
+		return fmt.Errorf("reference %v: has %d ID words, but must have 1 to %d", ref, n, maxWords)
+	}
+
+	fits := ref.Id[0] < 1<<18 && ref.Creation < 1<<2 && n <= 3
+	tag, err := e.identifierTag(ettNewRef, ettNewerRef, fits)
+	if err != nil {
+		return fmt.Errorf("reference %v: %w", ref, err)
+	}
+
+	err = e.header(tag, byte(n>>8), byte(n))
 	if err != nil {
 		return
 	}
 	if err = e.writeAtom(ref.Node); err != nil {
 		return
 	}
-	if _, err = e.w.Write([]byte{ref.Creation}); err != nil {
+	if err = e.writeCreation(tag, ref.Creation); err != nil {
 		return
 	}
 	for _, v := range ref.Id {
-		b := []byte{
-			byte(v >> 24),
-			byte(v >> 16),
-			byte(v >> 8),
-			byte(v),
-		}
-		if _, err = e.w.Write(b); err != nil {
+		if err = e.header(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)); err != nil {
 			return
 		}
 	}
@@ -327,26 +834,83 @@ func (e *Encoder) writeRef(ref Ref) (err error) {
 	return
 }
 
+func (e *Encoder) writeExport(x Export) (err error) {
+	// $qM…F…A
+	if err = e.header(ettExport); err != nil {
+		return
+	} else if err = e.writeAtom(x.Module); err != nil {
+		return
+	} else if err = e.writeAtom(x.Function); err != nil {
+		return
+	}
+	// The arity is a SMALL_INTEGER_EXT term, not a bare byte.
+	return e.writeUint(uint64(x.Arity))
+}
+
+func (e *Encoder) writeFun(f Function) (err error) {
+	// $pSSSSAUUUUUUUUUUUUUUUUIIIIFFFFM…i…u…P…[V…]
+	if int(f.Free) != len(f.FreeVars) {
+		return fmt.Errorf("fun: has %d free variables, but Free is %d", len(f.FreeVars), f.Free)
+	}
+
+	// The size is filled in once the rest of the fun is written.
+	start := e.buf.Len()
+	if err = e.header(ettNewFun, 0, 0, 0, 0, f.Arity); err != nil {
+		return
+	} else if err = e.header(f.Unique[:]...); err != nil {
+		return
+	}
+	err = e.header(
+		byte(f.Index>>24), byte(f.Index>>16), byte(f.Index>>8), byte(f.Index),
+		byte(f.Free>>24), byte(f.Free>>16), byte(f.Free>>8), byte(f.Free),
+	)
+	if err != nil {
+		return
+	} else if err = e.writeAtom(f.Module); err != nil {
+		return
+	} else if err = e.writeUint(uint64(f.OldIndex)); err != nil {
+		return
+	} else if err = e.writeUint(uint64(f.OldUnique)); err != nil {
+		return
+	} else if err = e.writePid(f.Pid); err != nil {
+		return
+	}
+	for _, v := range f.FreeVars {
+		if err = e.encodeTerm(v); err != nil {
+			return
+		}
+	}
+
+	// The size counts itself, but not the tag.
+	size := uint64(e.buf.Len() - start - 1)
+	if size > math.MaxUint32 {
+		return fmt.Errorf("fun: is too big (%d bytes)", size)
+	}
+	b := e.buf.Bytes()[start+1:]
+	b[0], b[1], b[2], b[3] = byte(size>>24), byte(size>>16), byte(size>>8), byte(size)
+	return nil
+}
+
 func (e *Encoder) writeTuple(tuple Tuple) (err error) {
 	n := len(tuple)
 	if n <= math.MaxUint8 {
-		_, err = e.w.Write([]byte{ettSmallTuple, byte(n)})
+		err = e.header(ettSmallTuple, byte(n))
 	} else {
-		_, err = e.w.Write([]byte{
+		err = e.header(
 			ettLargeTuple,
-			byte(n >> 24),
-			byte(n >> 16),
-			byte(n >> 8),
+			byte(n>>24),
+			byte(n>>16),
+			byte(n>>8),
 			byte(n),
-		})
+		)
 	}
 
 	if err != nil {
 		return
 	}
 
-	for _, v := range tuple {
-		if err = e.EncodeTerm(v); err != nil {
+	for i, v := range tuple {
+		if err = e.encodeAt(pathElem{kind: pathTuple, index: i}, v); err != nil {
 			return
 		}
 	}
@@ -358,8 +922,20 @@ func (e *Encoder) writeTuple(tuple Tuple) (err error) {
 // supported.
 type ErrUnknownType struct {
 	t reflect.Type
+
+	// Path is the position of the value inside of the term being
+	// encoded, in the same form as DecodeError.Path. Struct fields are
+	// given by name, such as ".Field".
+	Path string
 }
 
 func (e *ErrUnknownType) Error() string {
-	return fmt.Sprintf("write: can't encode type \"%s\"", e.t.Name())
+	name := "nil"
+	if e.t != nil {
+		name = e.t.String()
+	}
+	if e.Path == "" {
+		return fmt.Sprintf("write: can't encode type \"%s\"", name)
+	}
+	return fmt.Sprintf("write: can't encode type \"%s\" at %s", name, e.Path)
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"math/rand/v2"
+	"strings"
 	"testing"
 )
 
@@ -17,9 +18,10 @@ func BenchmarkWriteAtom(b *testing.B) {
 	atoms := make([]Atom, max)
 
 	for i := 0; i < max; i++ {
-		atoms[i] = Atom(bytes.Repeat([]byte{byte('A' + i)}, length))
+		atoms[i] = Atom(strings.Repeat(string(rune('A'+i)), length))
 	}
 
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -27,6 +29,7 @@ func BenchmarkWriteAtom(b *testing.B) {
 		if err := e.writeAtom(in); err != nil {
 			b.Fatal(in, err)
 		}
+		e.Flush()
 	}
 }
 
@@ -73,6 +76,7 @@ func BenchmarkWriteBinary(b *testing.B) {
 		)
 	}
 
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -80,6 +84,7 @@ func BenchmarkWriteBinary(b *testing.B) {
 		if err := e.writeBinary(in); err != nil {
 			b.Fatal(in, err)
 		}
+		e.Flush()
 	}
 }
 
@@ -95,6 +100,7 @@ func BenchmarkWriteBool(b *testing.B) {
 		bools[i] = (rand.N(2) == 1)
 	}
 
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -102,6 +108,7 @@ func BenchmarkWriteBool(b *testing.B) {
 		if err := e.writeBool(in); err != nil {
 			b.Fatal(in, err)
 		}
+		e.Flush()
 	}
 }
 
@@ -117,6 +124,7 @@ func BenchmarkWriteFloat(b *testing.B) {
 		floats[i] = rand.ExpFloat64() - rand.ExpFloat64()
 	}
 
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -124,6 +132,7 @@ func BenchmarkWriteFloat(b *testing.B) {
 		if err := e.writeFloat(in); err != nil {
 			b.Fatal(in, err)
 		}
+		e.Flush()
 	}
 }
 
@@ -139,6 +148,7 @@ func BenchmarkWriteInt(b *testing.B) {
 		ints[i] = int64(rand.Int32() - rand.Int32())
 	}
 
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -146,6 +156,7 @@ func BenchmarkWriteInt(b *testing.B) {
 		if err := e.writeInt(in); err != nil {
 			b.Fatal(in, err)
 		}
+		e.Flush()
 	}
 }
 
@@ -161,6 +172,7 @@ func BenchmarkWriteUint(b *testing.B) {
 		ints[i] = uint64(rand.Int32())
 	}
 
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -168,6 +180,7 @@ func BenchmarkWriteUint(b *testing.B) {
 		if err := e.writeUint(in); err != nil {
 			b.Fatal(in, err)
 		}
+		e.Flush()
 	}
 }
 
@@ -188,10 +201,11 @@ func BenchmarkWritePid(b *testing.B) {
 			Atom(b),
 			rand.N[uint32](65536),
 			rand.N[uint32](256),
-			rand.N[byte](16),
+			rand.N[uint32](16),
 		}
 	}
 
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -199,6 +213,7 @@ func BenchmarkWritePid(b *testing.B) {
 		if err := e.writePid(in); err != nil {
 			b.Fatal(in, err)
 		}
+		e.Flush()
 	}
 }
 
@@ -216,6 +231,7 @@ func BenchmarkWriteString(b *testing.B) {
 		strings[i] = string(bytes.Map(randRune, s))
 	}
 
+	b.ReportAllocs()
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -223,5 +239,62 @@ func BenchmarkWriteString(b *testing.B) {
 		if err := e.writeString(in); err != nil {
 			b.Fatal(in, err)
 		}
+		e.Flush()
+	}
+}
+
+// BenchmarkWriteResetEncoder encodes framed terms, reusing an Encoder
+// and its buffer by resetting it for each one.
+func BenchmarkWriteResetEncoder(b *testing.B) {
+	b.StopTimer()
+	c := new(Context)
+	e := c.Encoder(io.Discard, EncodePacket(4))
+
+	max := 64
+	terms := make([]Term, max)
+
+	for i := 0; i < max; i++ {
+		terms[i] = Tuple{Atom("ok"), i, []byte("binary")}
+	}
+
+	var w bytes.Buffer
+
+	b.ReportAllocs()
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		e.Reset(&w)
+		if err := e.Encode(terms[i%max]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteNewEncoder encodes the same terms as
+// BenchmarkWriteResetEncoder, but allocates a new Encoder for each one
+// instead of reusing one.
+func BenchmarkWriteNewEncoder(b *testing.B) {
+	b.StopTimer()
+	c := new(Context)
+
+	max := 64
+	terms := make([]Term, max)
+
+	for i := 0; i < max; i++ {
+		terms[i] = Tuple{Atom("ok"), i, []byte("binary")}
+	}
+
+	var w bytes.Buffer
+
+	b.ReportAllocs()
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		e := c.Encoder(&w, EncodePacket(4))
+		if err := e.Encode(terms[i%max]); err != nil {
+			b.Fatal(err)
+		}
 	}
 }
@@ -2,9 +2,11 @@ package etf
 
 import (
 	"bytes"
+	"errors"
 	"math"
 	"math/big"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -13,7 +15,11 @@ func TestWriteAtom(t *testing.T) {
 	test := func(in Atom, shouldFail bool) {
 		w := new(bytes.Buffer)
 		e := c.Encoder(w)
-		if err := e.writeAtom(in); err != nil {
+		err := e.writeAtom(in)
+		if err == nil {
+			err = e.Flush()
+		}
+		if err != nil {
 			if !shouldFail {
 				t.Error(in, err)
 			}
@@ -30,9 +36,59 @@ func TestWriteAtom(t *testing.T) {
 
 	test(Atom(""), false)
 	test(Atom(bytes.Repeat([]byte{'a'}, math.MaxUint8)), false)
-	test(Atom(bytes.Repeat([]byte{'a'}, math.MaxUint8+1)), false)
-	test(Atom(bytes.Repeat([]byte{'a'}, math.MaxUint16)), false)
-	test(Atom(bytes.Repeat([]byte{'a'}, math.MaxUint16+1)), true)
+	test(Atom(bytes.Repeat([]byte{'a'}, math.MaxUint8+1)), true)
+	test(Atom(strings.Repeat("é", math.MaxUint8)), false)
+	test(Atom(strings.Repeat("ᚠ", math.MaxUint8)), false)
+	test(Atom(strings.Repeat("ᚠ", math.MaxUint8+1)), true)
+	test(Atom("\xff"), true)
+}
+
+func TestWriteAtomTags(t *testing.T) {
+	c := new(Context)
+	test := func(in Atom, latin1 bool, out []byte) {
+		w := new(bytes.Buffer)
+		e := c.Encoder(w, EncodeLatin1Atoms(latin1))
+		err := e.writeAtom(in)
+		if err == nil {
+			err = e.Flush()
+		}
+		if err != nil {
+			t.Error(in, err)
+		} else if !bytes.Equal(w.Bytes(), out) {
+			t.Errorf("%v: expected %v, got %v", in, out, w.Bytes())
+		} else if v, err := c.Decoder(w).Decode(); err != nil {
+			t.Error(in, err)
+		} else if v != in {
+			t.Errorf("expected %v, got %v", in, v)
+		}
+	}
+
+	test("ok", false, []byte{ettSmallAtomUTF8, 2, 'o', 'k'})
+	test("ok", true, []byte{ettSmallAtom, 2, 'o', 'k'})
+	test("é", false, []byte{ettSmallAtomUTF8, 2, 0xc3, 0xa9})
+	test("é", true, []byte{ettSmallAtom, 1, 0xe9})
+
+	long := Atom(strings.Repeat("é", math.MaxUint8))
+	test(long, false, append([]byte{ettAtomUTF8, 1, 254}, long...))
+
+	if err := c.Encoder(new(bytes.Buffer), EncodeLatin1Atoms(true)).writeAtom("ᚠ"); err == nil {
+		t.Error("err == nil for non-Latin-1 atom")
+	}
+}
+
+func TestReadLatin1Atom(t *testing.T) {
+	c := new(Context)
+	for _, in := range [][]byte{
+		{ettSmallAtom, 3, 'c', 0xe9, 'u'},
+		{ettAtom, 0, 3, 'c', 0xe9, 'u'},
+	} {
+		v, err := c.Decoder(bytes.NewReader(in)).Decode()
+		if err != nil {
+			t.Error(in, err)
+		} else if v != Atom("céu") {
+			t.Errorf("%v: expected céu, got %v", in, v)
+		}
+	}
 }
 
 func TestWriteBinary(t *testing.T) {
@@ -40,7 +96,11 @@ func TestWriteBinary(t *testing.T) {
 	test := func(in []byte) {
 		w := new(bytes.Buffer)
 		e := c.Encoder(w)
-		if err := e.writeBinary(in); err != nil {
+		err := e.writeBinary(in)
+		if err == nil {
+			err = e.Flush()
+		}
+		if err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Decoder(w).Decode(); err != nil {
 			t.Error(in, err)
@@ -61,7 +121,11 @@ func TestWriteBool(t *testing.T) {
 	test := func(in bool) {
 		w := new(bytes.Buffer)
 		e := c.Encoder(w)
-		if err := e.writeBool(in); err != nil {
+		err := e.writeBool(in)
+		if err == nil {
+			err = e.Flush()
+		}
+		if err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Decoder(w).Decode(); err != nil {
 			t.Error(in, err)
@@ -81,7 +145,11 @@ func TestWriteFloat(t *testing.T) {
 	test := func(in float64) {
 		w := new(bytes.Buffer)
 		e := c.Encoder(w)
-		if err := e.writeFloat(in); err != nil {
+		err := e.writeFloat(in)
+		if err == nil {
+			err = e.Flush()
+		}
+		if err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Decoder(w).Decode(); err != nil {
 			t.Error(in, err)
@@ -103,7 +171,11 @@ func TestWriteInt(t *testing.T) {
 	test := func(in int64) {
 		w := new(bytes.Buffer)
 		e := c.Encoder(w)
-		if err := e.writeInt(in); err != nil {
+		err := e.writeInt(in)
+		if err == nil {
+			err = e.Flush()
+		}
+		if err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Decoder(w).Decode(); err != nil {
 			t.Error(in, err)
@@ -131,7 +203,11 @@ func TestWriteUint(t *testing.T) {
 	test := func(in uint64) {
 		w := new(bytes.Buffer)
 		e := c.Encoder(w)
-		if err := e.writeUint(in); err != nil {
+		err := e.writeUint(in)
+		if err == nil {
+			err = e.Flush()
+		}
+		if err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Decoder(w).Decode(); err != nil {
 			t.Error(in, err)
@@ -161,12 +237,47 @@ func TestWriteUint(t *testing.T) {
 	test(math.MaxUint64)
 }
 
+func TestWriteBigInt(t *testing.T) {
+	c := new(Context)
+	test := func(in Term, exp Term, expLen int) {
+		w := new(bytes.Buffer)
+		e := c.Encoder(w)
+		if err := e.EncodeTerm(in); err != nil {
+			t.Error(in, err)
+		} else if l := w.Len(); l != expLen {
+			t.Errorf("%v: expected %d bytes, got %d", in, expLen, l)
+		} else if v, err := c.Decoder(w).Decode(); err != nil {
+			t.Error(in, err)
+		} else if !Equal(v, exp) {
+			t.Errorf("expected %v, got %v", exp, v)
+		}
+	}
+
+	big1 := new(big.Int).Lsh(big.NewInt(1), 64)
+
+	test(big.NewInt(1), 1, 2)
+	test(*big.NewInt(-1), -1, 5)
+	test(big1, big1, 12)
+	test(*big1, big1, 12)
+	test(big.NewRat(10, 2), 5, 2)
+	test(*new(big.Rat).SetInt(big1), big1, 12)
+
+	e := c.Encoder(new(bytes.Buffer))
+	if err := e.EncodeTerm(big.NewRat(1, 2)); err == nil {
+		t.Error("err == nil")
+	}
+}
+
 func TestWritePid(t *testing.T) {
 	c := new(Context)
 	test := func(in Pid) {
 		w := new(bytes.Buffer)
 		e := c.Encoder(w)
-		if err := e.writePid(in); err != nil {
+		err := e.writePid(in)
+		if err == nil {
+			err = e.Flush()
+		}
+		if err != nil {
 			t.Error(in, err)
 		} else if v, err := c.Decoder(w).Decode(); err != nil {
 			t.Error(in, err)
@@ -181,12 +292,102 @@ func TestWritePid(t *testing.T) {
 	test(Pid{Atom("self@localhost"), 32, 1, 9})
 }
 
+func TestWritePort(t *testing.T) {
+	c := new(Context)
+	test := func(in Port) {
+		w := new(bytes.Buffer)
+		e := c.Encoder(w)
+		err := e.writePort(in)
+		if err == nil {
+			err = e.Flush()
+		}
+		if err != nil {
+			t.Error(in, err)
+		} else if v, err := c.Decoder(w).Decode(); err != nil {
+			t.Error(in, err)
+		} else if l := w.Len(); l != 0 {
+			t.Errorf("%v: buffer len %d", in, l)
+		} else if v != in {
+			t.Errorf("expected %v, got %v", in, v)
+		}
+	}
+
+	test(Port{Atom("omg@lol"), 38, 3})
+	test(Port{Atom("self@localhost"), math.MaxUint32 + 1, math.MaxUint32})
+}
+
+func TestWriteTarget(t *testing.T) {
+	c := new(Context)
+	pid := Pid{Atom("a"), 1, 2, 3}
+	port := Port{Atom("a"), 1, 3}
+	bigPort := Port{Atom("a"), math.MaxUint32 + 1, 3}
+	ref := Ref{Atom("a"), 3, []uint32{1}}
+
+	test := func(in Term, target int, out []byte) {
+		w := new(bytes.Buffer)
+		if err := c.Encoder(w, TargetOTP(target)).EncodeTerm(in); err != nil {
+			t.Errorf("%v (OTP %d): %v", in, target, err)
+		} else if !bytes.Equal(w.Bytes(), out) {
+			t.Errorf("%v (OTP %d): expected %v, got %v", in, target, out, w.Bytes())
+		} else if v, err := c.Decoder(w).Decode(); err != nil {
+			t.Error(in, err)
+		} else if !Equal(v, in) {
+			t.Errorf("expected %v, got %v", in, v)
+		}
+	}
+	fail := func(in Term, target int) {
+		if err := c.Encoder(new(bytes.Buffer), TargetOTP(target)).EncodeTerm(in); err == nil {
+			t.Errorf("%v (OTP %d): err == nil", in, target)
+		}
+	}
+
+	test(Atom("é"), 19, []byte{ettSmallAtom, 1, 0xe9})
+	test(Atom("é"), 20, []byte{ettSmallAtomUTF8, 2, 0xc3, 0xa9})
+	test(true, 19, []byte{ettSmallAtom, 4, 't', 'r', 'u', 'e'})
+
+	test(pid, 22, []byte{ettPid, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 1, 0, 0, 0, 2, 3})
+	test(pid, 23, []byte{ettNewPid, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3})
+	test(pid, 0, []byte{ettNewPid, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3})
+
+	// Values that don't fit in the old tags select the new ones, if the
+	// target supports them.
+	test(Pid{Atom("a"), 1 << 15, 2, 3}, 22, []byte{ettNewPid, ettSmallAtomUTF8, 1, 'a', 0, 0, 0x80, 0, 0, 0, 0, 2, 0, 0, 0, 3})
+	test(Pid{Atom("a"), 1, 1 << 13, 3}, 22, []byte{ettNewPid, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 1, 0, 0, 0x20, 0, 0, 0, 0, 3})
+	test(Pid{Atom("a"), 1, 2, 4}, 22, []byte{ettNewPid, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 4})
+	test(pid, 18, []byte{ettPid, ettSmallAtom, 1, 'a', 0, 0, 0, 1, 0, 0, 0, 2, 3})
+	fail(Pid{Atom("a"), 1 << 15, 2, 3}, 18)
+	fail(Pid{Atom("a"), 1, 2, 4}, 18)
+
+	test(port, 22, []byte{ettPort, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 1, 3})
+	test(port, 23, []byte{ettNewPort, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 1, 0, 0, 0, 3})
+	test(bigPort, 24, []byte{ettV4Port, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 3})
+	fail(bigPort, 23)
+	test(Port{Atom("a"), 1 << 28, 3}, 22, []byte{ettNewPort, ettSmallAtomUTF8, 1, 'a', 0x10, 0, 0, 0, 0, 0, 0, 3})
+	fail(Port{Atom("a"), 1 << 28, 3}, 18)
+
+	test(ref, 22, []byte{ettNewRef, 0, 1, ettSmallAtomUTF8, 1, 'a', 3, 0, 0, 0, 1})
+	test(ref, 23, []byte{ettNewerRef, 0, 1, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 3, 0, 0, 0, 1})
+	test(Ref{Atom("a"), 3, []uint32{1 << 18}}, 22, []byte{ettNewerRef, 0, 1, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 3, 0, 4, 0, 0})
+	fail(Ref{Atom("a"), 3, []uint32{1 << 18}}, 18)
+	test(Ref{Atom("a"), 3, []uint32{1, 2, 3, 4, 5}}, 24, []byte{
+		ettNewerRef, 0, 5, ettSmallAtomUTF8, 1, 'a', 0, 0, 0, 3,
+		0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 4, 0, 0, 0, 5,
+	})
+	fail(Ref{Atom("a"), 3, []uint32{1, 2, 3, 4}}, 23)
+	fail(Ref{Atom("a"), 3, []uint32{1, 2, 3, 4, 5, 6}}, 0)
+	fail(Ref{Atom("a"), 3, nil}, 0)
+}
+
 func TestWriteString(t *testing.T) {
 	c := new(Context)
 	test := func(in string, shouldFail bool) {
 		w := new(bytes.Buffer)
 		e := c.Encoder(w)
-		if err := e.writeString(in); err != nil {
+		err := e.writeString(in)
+		if err == nil {
+			err = e.Flush()
+		}
+		if err != nil {
 			if !shouldFail {
 				t.Error(in, err)
 			}
@@ -203,7 +404,81 @@ func TestWriteString(t *testing.T) {
 
 	test(string(bytes.Repeat([]byte{'a'}, math.MaxUint16)), false)
 	test("", false)
-	test(string(bytes.Repeat([]byte{'a'}, math.MaxUint16+1)), true)
+}
+
+func TestWriteStringEncoding(t *testing.T) {
+	c := new(Context)
+	test := func(in any, mode StringEncoding, out []byte) {
+		w := new(bytes.Buffer)
+		if err := c.Encoder(w, EncodeStringsAs(mode)).Encode(in); err != nil {
+			t.Error(in, err)
+		} else if !bytes.Equal(w.Bytes()[1:], out) {
+			t.Errorf("%q: expected %v, got %v", in, out, w.Bytes()[1:])
+		}
+	}
+
+	test("ok", StringAsBytes, []byte{ettString, 0, 2, 'o', 'k'})
+	test("é", StringAsBytes, []byte{ettString, 0, 2, 0xc3, 0xa9})
+	test("ok", StringAsBinary, []byte{ettBinary, 0, 0, 0, 2, 'o', 'k'})
+	test("é", StringAsBinary, []byte{ettBinary, 0, 0, 0, 2, 0xc3, 0xa9})
+	test("é", StringAsCharlist, []byte{ettString, 0, 1, 0xe9})
+	test("ᚠ", StringAsCharlist, []byte{ettList, 0, 0, 0, 1, ettInteger, 0, 0, 0x16, 0xa0, ettNil})
+
+	type record struct {
+		A string
+		B string `etf:"binary"`
+		C string `etf:"charlist"`
+		D string `etf:"bytes"`
+	}
+	test(record{"a", "b", "ĉ", "d"}, StringAsBinary, []byte{
+		ettSmallTuple, 4,
+		ettBinary, 0, 0, 0, 1, 'a',
+		ettBinary, 0, 0, 0, 1, 'b',
+		ettList, 0, 0, 0, 1, ettInteger, 0, 0, 1, 9, ettNil,
+		ettString, 0, 1, 'd',
+	})
+
+	if err := c.Encoder(new(bytes.Buffer), EncodeStringsAs(StringAsCharlist)).Encode("\xff"); err == nil {
+		t.Error("err == nil for invalid UTF-8")
+	}
+	if err := c.Encoder(new(bytes.Buffer)).Encode(struct {
+		A string `etf:"utf16"`
+	}{}); err == nil {
+		t.Error("err == nil for unknown string encoding")
+	}
+
+	// Strings too long for STRING_EXT become lists.
+	long := strings.Repeat("a", math.MaxUint16+1)
+	w := new(bytes.Buffer)
+	if err := c.Encoder(w).Encode(long); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Decoder(w, DetectCharlists(true)).Decode(); err != nil {
+		t.Error(err)
+	} else if v != Charlist(long) {
+		t.Errorf("expected a charlist of %d bytes, got %T", len(long), v)
+	}
+}
+
+func TestWriteMap(t *testing.T) {
+	c := new(Context)
+	test := func(in Term) {
+		w := new(bytes.Buffer)
+		e := c.Encoder(w)
+		if err := e.EncodeTerm(in); err != nil {
+			t.Error(in, err)
+		} else if v, err := c.Decoder(w).Decode(); err != nil {
+			t.Error(in, err)
+		} else if l := w.Len(); l != 0 {
+			t.Errorf("%v: buffer len %d", in, l)
+		} else if !Equal(v, in) {
+			t.Errorf("expected %v, got %v", in, v)
+		}
+	}
+
+	test(Map{})
+	test(Map{{Atom("a"), 1}, {Tuple{1, 2}, List{Atom("b")}}})
+	test(ImproperList{List{1, 2}, Atom("tail")})
 }
 
 func TestWriteTerm(t *testing.T) {
@@ -253,3 +528,162 @@ func TestWriteTerm(t *testing.T) {
 		}
 	}
 }
+
+func TestEncoderReset(t *testing.T) {
+	c := new(Context)
+	var w1, w2 bytes.Buffer
+	e := c.Encoder(&w1, EncodeStringsAs(StringAsBinary))
+	if err := e.Encode("a"); err != nil {
+		t.Fatal(err)
+	}
+	e.Reset(&w2)
+	if err := e.Encode("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []byte{EtVersion, ettBinary, 0, 0, 0, 1, 'a'}; !bytes.Equal(w1.Bytes(), exp) {
+		t.Errorf("expected %v, got %v", exp, w1.Bytes())
+	}
+	if exp := []byte{EtVersion, ettBinary, 0, 0, 0, 1, 'b'}; !bytes.Equal(w2.Bytes(), exp) {
+		t.Errorf("expected %v, got %v", exp, w2.Bytes())
+	}
+}
+
+// countingWriter counts the calls to Write.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestEncoderFlush(t *testing.T) {
+	c := new(Context)
+	w := new(countingWriter)
+	e := c.Encoder(w)
+
+	in := Tuple{Atom("ok"), List{1, 2, 3}, "text", Pid{Atom("a@h"), 1, 2, 3}}
+	if err := e.Encode(in); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Errorf("expected 1 write, got %d", w.writes)
+	}
+	if e.Buffered() != 0 {
+		t.Errorf("%d bytes still buffered", e.Buffered())
+	}
+
+	// A term that can't be encoded leaves nothing behind.
+	if err := e.Encode(Tuple{Atom("ok"), make(chan int)}); err == nil {
+		t.Error("err == nil for unencodable term")
+	}
+	if w.writes != 1 || e.Buffered() != 0 {
+		t.Errorf("failed Encode wrote %d times and buffered %d bytes", w.writes-1, e.Buffered())
+	}
+
+	// EncodeTerm flushes each term like Encode, and also leaves
+	// nothing behind if it fails.
+	if err := e.EncodeTerm(Atom("ok")); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 2 {
+		t.Errorf("expected 2 writes, got %d", w.writes)
+	}
+	if err := e.EncodeTerm(List{1, make(chan int)}); err == nil {
+		t.Error("err == nil for unencodable term")
+	}
+	if w.writes != 2 || e.Buffered() != 0 {
+		t.Errorf("failed EncodeTerm wrote %d times and buffered %d bytes", w.writes-2, e.Buffered())
+	}
+}
+
+func TestEncoderPacket(t *testing.T) {
+	c := new(Context)
+	test := func(size int, in Term, out []byte) {
+		w := new(countingWriter)
+		if err := c.Encoder(w, EncodePacket(size)).Encode(in); err != nil {
+			t.Errorf("%v (packet %d): %v", in, size, err)
+		} else if !bytes.Equal(w.Bytes(), out) {
+			t.Errorf("%v (packet %d): expected %v, got %v", in, size, out, w.Bytes())
+		} else if w.writes != 1 {
+			t.Errorf("%v (packet %d): expected 1 write, got %d", in, size, w.writes)
+		}
+	}
+
+	test(0, 1, []byte{EtVersion, ettSmallInteger, 1})
+	test(1, 1, []byte{3, EtVersion, ettSmallInteger, 1})
+	test(2, 1, []byte{0, 3, EtVersion, ettSmallInteger, 1})
+	test(4, Atom("ok"), []byte{0, 0, 0, 5, EtVersion, ettSmallAtomUTF8, 2, 'o', 'k'})
+
+	e := c.Encoder(new(bytes.Buffer), EncodePacket(1))
+	if err := e.Encode(make([]byte, 256)); err == nil {
+		t.Error("err == nil for oversized frame")
+	}
+	if e.Buffered() != 0 {
+		t.Errorf("%d bytes buffered after oversized frame", e.Buffered())
+	}
+	if err := c.Encoder(new(bytes.Buffer), EncodePacket(3)).Encode(1); err == nil {
+		t.Error("err == nil for invalid packet size")
+	}
+}
+
+func TestWriteUnknownType(t *testing.T) {
+	c := new(Context)
+	test := func(in Term, path string) {
+		err := c.Encoder(new(bytes.Buffer)).Encode(in)
+		var unknown *ErrUnknownType
+		if !errors.As(err, &unknown) {
+			t.Errorf("%v: expected *ErrUnknownType, got %v", in, err)
+		} else if unknown.Path != path {
+			t.Errorf("%v: expected path %q, got %q", in, path, unknown.Path)
+		}
+	}
+
+	type record struct {
+		A int
+		B chan int
+	}
+	test(make(chan int), "")
+	test(Tuple{1, List{2, record{}}}, "{1}[1].B")
+	test(Map{{Atom("a"), 1}, {Atom("b"), nil}}, "#{1}.value")
+	test(ImproperList{List{1}, (*int)(nil)}, "|")
+}
+
+func TestWritePointer(t *testing.T) {
+	c := new(Context)
+	x := 5
+	w := new(bytes.Buffer)
+	if err := c.Encoder(w).Encode(Tuple{&x}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Decoder(w).Decode(); err != nil {
+		t.Error(err)
+	} else if !Equal(v, Tuple{5}) {
+		t.Errorf("expected {5}, got %v", v)
+	}
+}
+
+func TestWriteFun(t *testing.T) {
+	c := new(Context)
+	for _, in := range []Term{
+		Export{Module: "lists", Function: "map", Arity: 2},
+		Function{Arity: 2, Module: "m", Free: 2, FreeVars: []Term{1, Atom("x")}, Pid: Pid{Node: "a@host", Id: 1}},
+	} {
+		w := new(bytes.Buffer)
+		if err := c.Encoder(w).Encode(in); err != nil {
+			t.Errorf("%v: %v", in, err)
+		} else if v, err := c.Decoder(w).Decode(); err != nil {
+			t.Errorf("%v: %v", in, err)
+		} else if !reflect.DeepEqual(v, in) {
+			t.Errorf("expected %#v, got %#v", in, v)
+		}
+	}
+
+	err := c.Encoder(new(bytes.Buffer)).Encode(Function{Free: 1})
+	if err == nil {
+		t.Error("err == nil for a fun without its free variable")
+	}
+}